@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"oscen/historyimport"
+	"oscen/interactions"
+	"oscen/repositories/listens"
+	"strconv"
 
+	"github.com/Postcord/objects"
 	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -30,6 +37,8 @@ func main() {
 
 	root.AddCommand(ListApplicationCommands(dc, logger))
 	root.AddCommand(ResetApplicationCommands(dc, logger))
+	root.AddCommand(ImportHistory(logger))
+	root.AddCommand(SyncCommands(logger))
 
 	if err := root.Execute(); err != nil {
 		logger.Fatal("failed to execute command", zap.Error(err))
@@ -88,6 +97,109 @@ func ResetApplicationCommands(session *discordgo.Session, logger *zap.Logger) *c
 	}
 }
 
+func SyncCommands(logger *zap.Logger) *cobra.Command {
+	dryRun := false
+
+	cmd := &cobra.Command{
+		Use:   "sync-commands <guildId>",
+		Short: "Makes the registered application commands match the ones Oscen provides",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var guildID *objects.Snowflake // by default global
+			if len(args) == 1 {
+				id, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					return err
+				}
+				snowflake := objects.Snowflake(id)
+				guildID = &snowflake
+			}
+
+			client := interactions.NewCommandsClient("Bot " + os.Getenv("DISCORD_BOT_TOKEN"))
+			// Only the command definitions are needed, so no dependencies.
+			commands := interactions.Commands(interactions.Dependencies{})
+
+			plan, err := interactions.SyncCommands(cmd.Context(), logger, client, guildID, commands, dryRun)
+			if err != nil {
+				return err
+			}
+
+			if plan.Empty() {
+				logger.Info("commands are already up to date")
+			} else if dryRun {
+				logger.Info("dry run, no changes made")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "only show what would change")
+
+	return cmd
+}
+
+func ImportHistory(logger *zap.Logger) *cobra.Command {
+	return &cobra.Command{
+		Use:   "import-history <discordId> <file>...",
+		Short: "Imports Spotify extended streaming history files for a user",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			discordID := args[0]
+			ctx := cmd.Context()
+
+			importer := historyimport.New()
+			for _, path := range args[1:] {
+				logger.Info("parsing history file", zap.String("path", path))
+				if err := importFile(importer, path); err != nil {
+					return err
+				}
+			}
+
+			db, err := connectToDatabase(ctx)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			listensRepo := listens.NewPostgresRepository(db)
+			inserted, err := listensRepo.ImportListens(ctx, discordID, importer.Entries())
+			if err != nil {
+				return err
+			}
+
+			logger.Info("imported history",
+				zap.String("discord_id", discordID),
+				zap.Int("total", importer.Stats.Total),
+				zap.Int("accepted", importer.Stats.Accepted),
+				zap.Int("not_track", importer.Stats.NotTrack),
+				zap.Int("too_short", importer.Stats.TooShort),
+				zap.Int("duplicates", importer.Stats.Duplicates),
+				zap.Int("missing_track", importer.Stats.MissingTrack),
+				zap.Int64("inserted", inserted),
+			)
+			return nil
+		},
+	}
+}
+
+func importFile(importer *historyimport.Importer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return importer.Add(f)
+}
+
+func connectToDatabase(ctx context.Context) (*pgxpool.Pool, error) {
+	db, err := pgxpool.Connect(ctx, os.Getenv("POSTGRESQL_URL"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to db: %w", err)
+	}
+
+	return db, nil
+}
+
 func setupDiscordSession(log *zap.Logger) (*discordgo.Session, error) {
 	log.Info("instantiating discord session")
 	discordSession, err := discordgo.New("Bot " + os.Getenv("DISCORD_BOT_TOKEN"))
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
+	"oscen/repositories/guildmembers"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 func main() {
@@ -17,8 +20,17 @@ func main() {
 		log.Fatalf("failed to create discordgo: %s", err)
 	}
 
-	// Set intents to none so we dont recieve any traffic
-	discord.Identify.Intents = discordgo.IntentsNone
+	db, err := pgxpool.Connect(context.Background(), os.Getenv("POSTGRESQL_URL"))
+	if err != nil {
+		log.Fatalf("failed to connect to db: %s", err)
+	}
+	defer db.Close()
+
+	// Only ask for guild and member events, which keep guild_members up to
+	// date. The members intent must be enabled for the bot in the developer
+	// portal.
+	discord.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMembers
+	newMemberTracker(guildmembers.NewPostgresRepository(db)).register(discord)
 
 	discord.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
 		log.Println("connected")
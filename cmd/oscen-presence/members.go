@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"log"
+	"oscen/repositories/guildmembers"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// memberTracker keeps the guild_members table in sync with the gateway.
+//
+// Whenever a guild becomes available we ask for all of its members, which
+// Discord sends back in chunks. Once every chunk has been saved, anyone not
+// seen since we asked must have left while we weren't listening.
+//
+// Chunks don't say which request they answer, so only one request per guild
+// is made at a time.
+//
+// Members removed while a sync is running may still turn up in its chunks, so
+// they are remembered until it finishes and kept out of the table.
+type memberTracker struct {
+	repo guildmembers.Repository
+
+	mu    sync.Mutex
+	syncs map[string]*memberSync
+}
+
+// syncTimeout is how long a sync can wait for its chunks before another
+// GUILD_CREATE may start a new one.
+const syncTimeout = 10 * time.Minute
+
+// memberSync tracks the chunks of a guild's members received so far.
+type memberSync struct {
+	started time.Time
+	// saved holds the index of each chunk saved.
+	saved  map[int]bool
+	failed bool
+	// removed holds the IDs of members removed since the sync started.
+	removed map[string]bool
+}
+
+func newMemberSync() *memberSync {
+	return &memberSync{
+		started: time.Now(),
+		saved:   map[int]bool{},
+		removed: map[string]bool{},
+	}
+}
+
+func newMemberTracker(repo guildmembers.Repository) *memberTracker {
+	return &memberTracker{
+		repo:  repo,
+		syncs: map[string]*memberSync{},
+	}
+}
+
+func (mt *memberTracker) register(s *discordgo.Session) {
+	s.AddHandler(mt.ready)
+	s.AddHandler(mt.guildCreate)
+	s.AddHandler(mt.guildDelete)
+	s.AddHandler(mt.guildMembersChunk)
+	s.AddHandler(mt.guildMemberAdd)
+	s.AddHandler(mt.guildMemberRemove)
+}
+
+func (mt *memberTracker) ready(s *discordgo.Session, r *discordgo.Ready) {
+	// Requests made before reconnecting won't be answered, so they'd never
+	// finish and stop the guilds being synced again.
+	mt.mu.Lock()
+	mt.syncs = map[string]*memberSync{}
+	mt.mu.Unlock()
+}
+
+func (mt *memberTracker) guildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
+	// GUILD_CREATE is sent again when a guild comes back after an outage.
+	// Asking again while a sync is running would mix up the chunks of both
+	// requests, so let the running one finish instead. One whose chunks never
+	// arrived is given up on.
+	mt.mu.Lock()
+	ms, running := mt.syncs[g.ID]
+	running = running && time.Since(ms.started) < syncTimeout
+	if !running {
+		mt.syncs[g.ID] = newMemberSync()
+	}
+	mt.mu.Unlock()
+	if running {
+		return
+	}
+
+	// A limit of zero with an empty query asks for every member.
+	if err := s.RequestGuildMembers(g.ID, "", 0, false); err != nil {
+		log.Printf("failed to request members of guild %s: %s", g.ID, err)
+
+		mt.mu.Lock()
+		delete(mt.syncs, g.ID)
+		mt.mu.Unlock()
+	}
+}
+
+func (mt *memberTracker) guildDelete(s *discordgo.Session, g *discordgo.GuildDelete) {
+	// Guilds also go unavailable during outages, their members haven't gone
+	// anywhere.
+	if g.Unavailable {
+		return
+	}
+
+	if err := mt.repo.RemoveGuild(context.Background(), g.ID); err != nil {
+		log.Printf("failed to remove guild %s: %s", g.ID, err)
+	}
+}
+
+func (mt *memberTracker) guildMembersChunk(s *discordgo.Session, c *discordgo.GuildMembersChunk) {
+	ctx := context.Background()
+
+	ids := []string{}
+	mt.mu.Lock()
+	for _, member := range c.Members {
+		if !mt.removedDuringSync(c.GuildID, member.User.ID) {
+			ids = append(ids, member.User.ID)
+		}
+	}
+	mt.mu.Unlock()
+
+	err := mt.repo.AddMembers(ctx, c.GuildID, ids, time.Now())
+	if err != nil {
+		log.Printf("failed to add members of guild %s: %s", c.GuildID, err)
+	}
+
+	// A member removed while we were saving the chunk has to be removed again.
+	mt.mu.Lock()
+	readded := []string{}
+	for _, id := range ids {
+		if mt.removedDuringSync(c.GuildID, id) {
+			readded = append(readded, id)
+		}
+	}
+	mt.mu.Unlock()
+	for _, id := range readded {
+		if err := mt.repo.RemoveMember(ctx, c.GuildID, id); err != nil {
+			log.Printf("failed to remove member %s of guild %s: %s", id, c.GuildID, err)
+		}
+	}
+
+	// Handlers run concurrently, so chunks can be saved in any order. They
+	// are counted by index so one can't be counted twice.
+	mt.mu.Lock()
+	ms, done := mt.syncs[c.GuildID]
+	if done {
+		ms.saved[c.ChunkIndex] = true
+		ms.failed = ms.failed || err != nil
+		done = len(ms.saved) >= c.ChunkCount
+		if done {
+			delete(mt.syncs, c.GuildID)
+		}
+	}
+	mt.mu.Unlock()
+
+	// Members in a chunk that failed to save would look like they had left.
+	if !done || ms.failed {
+		return
+	}
+
+	pruned, err := mt.repo.PruneMembers(ctx, c.GuildID, ms.started)
+	if err != nil {
+		log.Printf("failed to prune members of guild %s: %s", c.GuildID, err)
+		return
+	}
+	log.Printf("synced members of guild %s, %d left", c.GuildID, pruned)
+}
+
+// removedDuringSync reports whether the member was removed since the guild's
+// running sync started. mt.mu must be held.
+func (mt *memberTracker) removedDuringSync(guildID string, userID string) bool {
+	ms, ok := mt.syncs[guildID]
+	return ok && ms.removed[userID]
+}
+
+func (mt *memberTracker) guildMemberAdd(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	// They're back, so chunks listing them are right again.
+	mt.mu.Lock()
+	if ms, ok := mt.syncs[m.GuildID]; ok {
+		delete(ms.removed, m.User.ID)
+	}
+	mt.mu.Unlock()
+
+	err := mt.repo.AddMembers(context.Background(), m.GuildID, []string{m.User.ID}, time.Now())
+	if err != nil {
+		log.Printf("failed to add member %s of guild %s: %s", m.User.ID, m.GuildID, err)
+	}
+}
+
+func (mt *memberTracker) guildMemberRemove(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+	mt.mu.Lock()
+	if ms, ok := mt.syncs[m.GuildID]; ok {
+		ms.removed[m.User.ID] = true
+	}
+	mt.mu.Unlock()
+
+	if err := mt.repo.RemoveMember(context.Background(), m.GuildID, m.User.ID); err != nil {
+		log.Printf("failed to remove member %s of guild %s: %s", m.User.ID, m.GuildID, err)
+	}
+}
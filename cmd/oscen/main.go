@@ -11,10 +11,17 @@ import (
 	"os/signal"
 	"oscen/historyscraper"
 	"oscen/interactions"
+	"oscen/leader"
+	"oscen/notifier"
 	"oscen/playlistcreator"
+	"oscen/ratelimit"
+	"oscen/repositories/catalog"
+	"oscen/repositories/guildmembers"
 	"oscen/repositories/listens"
+	"oscen/repositories/seen"
 	"oscen/repositories/users"
 	"strconv"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap/zapcore"
@@ -34,6 +41,10 @@ import (
 	"go.uber.org/zap"
 )
 
+// historyScraperLockID is the Postgres advisory lock held by the replica
+// running the history scraper.
+const historyScraperLockID = 0x6f7363656e
+
 func connectToDatabase(ctx context.Context) (*pgxpool.Pool, error) {
 	db, err := pgxpool.Connect(ctx, os.Getenv("POSTGRESQL_URL"))
 	if err != nil {
@@ -56,6 +67,7 @@ func setupSpotifyAuth() *spotifyauth.Authenticator {
 			spotifyauth.ScopeUserReadCurrentlyPlaying,
 			spotifyauth.ScopeUserReadRecentlyPlayed,
 			spotifyauth.ScopePlaylistModifyPublic,
+			spotifyauth.ScopePlaylistModifyPrivate,
 			spotifyauth.ScopeUserTopRead,
 		),
 	)
@@ -63,11 +75,14 @@ func setupSpotifyAuth() *spotifyauth.Authenticator {
 	return auth
 }
 
+func discordToken() string {
+	return "Bot " + os.Getenv("DISCORD_BOT_TOKEN")
+}
+
 func setupDiscord(log *zap.Logger) (*rest.Client, error) {
 	log.Info("setting up discord client")
-	token := "Bot " + os.Getenv("DISCORD_BOT_TOKEN")
 	discord := rest.New(&rest.Config{
-		Token:     token,
+		Token:     discordToken(),
 		UserAgent: "oscen",
 	})
 
@@ -99,6 +114,45 @@ func tracerProvider(url string) (*tracesdk.TracerProvider, error) {
 	return tp, nil
 }
 
+// envInt reads an integer from the environment, falling back to def if the
+// variable is not set.
+func envInt(log *zap.Logger, name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+
+	val, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Fatal("failed parsing environment variable",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+	}
+	return val
+}
+
+// deleteExpiredInteractions stops the seen interactions table growing
+// forever.
+func deleteExpiredInteractions(ctx context.Context, log *zap.Logger, seenRepo *seen.PostgresRepository) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := seenRepo.DeleteExpired(ctx)
+			if err != nil {
+				log.Warn("failed to delete expired interactions", zap.Error(err))
+				continue
+			}
+			log.Debug("deleted expired interactions", zap.Int64("count", deleted))
+		}
+	}
+}
+
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
@@ -112,7 +166,8 @@ func main() {
 		log.Fatal(err)
 	}
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	jaegerURL := os.Getenv("JAEGER_URL")
 	if jaegerURL != "" {
@@ -137,7 +192,16 @@ func main() {
 	defer db.Close()
 
 	listensRepo := listens.NewPostgresRepository(db)
-	usersRepo := users.NewPostgresRepository(db)
+	// Every Spotify client is built from a user loaded from usersRepo, so
+	// they all share this limiter.
+	spotifyLimiter := ratelimit.NewLimiter(
+		float64(envInt(logger, "SPOTIFY_RATE_LIMIT", 10)),
+		envInt(logger, "SPOTIFY_RATE_BURST", 20),
+	)
+	usersRepo := users.NewPostgresRepository(db, spotifyLimiter)
+	catalogRepo := catalog.NewPostgresRepository(db)
+	// Kept up to date by oscen-presence.
+	membersRepo := guildmembers.NewPostgresRepository(db)
 
 	auth := setupSpotifyAuth()
 
@@ -156,19 +220,27 @@ func main() {
 		decodedKey,
 		discord,
 	)
+	// Replicas share which interactions they've seen, so a replay can't be
+	// sent to a different one.
+	seenRepo := seen.NewPostgresRepository(db)
+	router.SetSeenInteractions(seenRepo)
+	go deleteExpiredInteractions(ctx, logger.Named("seen"), seenRepo)
 
 	plc := playlistcreator.New(
 		auth,
 		discord,
 		usersRepo,
+		membersRepo,
 		logger.Named("playlist-creator"),
 	)
-	err = router.Register(
-		interactions.NewNowPlayingInteraction(usersRepo, auth, listensRepo),
-		interactions.NewListenLeaderboardInteraction(listensRepo, discord),
-		interactions.NewRegisterInteraction(auth),
-		interactions.NewGenerateInteraction(usersRepo, auth, plc),
-	)
+	err = router.Register(interactions.Commands(interactions.Dependencies{
+		UsersRepo:       usersRepo,
+		ListensRepo:     listensRepo,
+		MembersRepo:     membersRepo,
+		CatalogRepo:     catalogRepo,
+		Auth:            auth,
+		PlaylistCreator: plc,
+	})...)
 	if err != nil {
 		logger.Fatal("failed to register routes", zap.Error(err))
 	}
@@ -188,7 +260,7 @@ func main() {
 		logger.Warn("applying commands to test guild", zap.Int("guild", val))
 	}
 
-	err = router.SyncInteractions(testGuild)
+	err = router.SyncInteractions(ctx, interactions.NewCommandsClient(discordToken()), testGuild)
 	if err != nil {
 		logger.Fatal("failed to sync interactions", zap.Error(err))
 	}
@@ -223,16 +295,40 @@ func main() {
 	}()
 
 	hl := historyscraper.HistoryScraper{
-		Log:         logger.Named("scraper"),
-		Auth:        auth,
-		ListensRepo: listensRepo,
-		UsersRepo:   usersRepo,
-		Interval:    time.Minute,
+		Log:               logger.Named("scraper"),
+		Auth:              auth,
+		ListensRepo:       listensRepo,
+		UsersRepo:         usersRepo,
+		CatalogRepo:       catalogRepo,
+		Interval:          time.Minute,
+		Concurrency:       envInt(logger, "SCRAPER_CONCURRENCY", 4),
+		MaxRequestsPerRun: envInt(logger, "SCRAPER_MAX_REQUESTS_PER_RUN", 0),
+	}
+	if os.Getenv("NOTIFY_REVOKED_LINKS") != "" {
+		hl.Notifier = notifier.New(discord)
 	}
-	go hl.Run(ctx)
+
+	// Every replica serves interactions, but only the leader scrapes history
+	// so we don't duplicate Spotify calls.
+	elector := leader.Elector{
+		Locker: leader.NewPostgresLocker(db),
+		Log:    logger.Named("leader"),
+		LockID: historyScraperLockID,
+	}
+	scraperDone := make(chan struct{})
+	go func() {
+		defer close(scraperDone)
+		elector.Run(ctx, hl.Run)
+	}()
 
 	logger.Info("setup finished")
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	<-stop
+
+	// Give up leadership cleanly so another replica can take over straight
+	// away.
+	cancel()
+	<-scraperDone
+	router.Wait()
 }
@@ -0,0 +1,118 @@
+package historyimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"oscen/repositories/listens"
+	"strings"
+	"time"
+)
+
+// DefaultMinPlayed matches the point at which Spotify itself counts a stream,
+// anything shorter was skipped.
+const DefaultMinPlayed = 30 * time.Second
+
+const trackURIPrefix = "spotify:track:"
+
+// entry is a single stream in Spotify's "Extended streaming history" export.
+// The older "Account data" export uses endTime and msPlayed, but doesn't
+// identify tracks by URI, so we can't import it.
+type entry struct {
+	Timestamp       string `json:"ts"`
+	EndTime         string `json:"endTime"`
+	MsPlayed        int    `json:"ms_played"`
+	SpotifyTrackURI string `json:"spotify_track_uri"`
+}
+
+// Stats describes what happened to each entry given to an Importer.
+type Stats struct {
+	Total        int
+	Accepted     int
+	NotTrack     int
+	TooShort     int
+	Duplicates   int
+	MissingTrack int
+}
+
+type listenKey struct {
+	trackID  string
+	playedAt time.Time
+}
+
+// Importer parses extended streaming history files into listens, skipping
+// anything that isn't a track, was skipped, or appears more than once.
+type Importer struct {
+	MinPlayed time.Duration
+
+	Stats   Stats
+	entries []listens.BatchWriteListenEntry
+	seen    map[listenKey]bool
+}
+
+func New() *Importer {
+	return &Importer{
+		MinPlayed: DefaultMinPlayed,
+		seen:      map[listenKey]bool{},
+	}
+}
+
+// Add parses a single export file.
+func (im *Importer) Add(r io.Reader) error {
+	entries := []entry{}
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return fmt.Errorf("could not parse streaming history: %w", err)
+	}
+
+	for _, e := range entries {
+		im.Stats.Total++
+
+		if e.SpotifyTrackURI == "" {
+			// Podcast episodes, and the legacy export, have no track URI.
+			if e.EndTime != "" {
+				im.Stats.MissingTrack++
+			} else {
+				im.Stats.NotTrack++
+			}
+			continue
+		}
+		if !strings.HasPrefix(e.SpotifyTrackURI, trackURIPrefix) {
+			im.Stats.NotTrack++
+			continue
+		}
+
+		if time.Duration(e.MsPlayed)*time.Millisecond < im.MinPlayed {
+			im.Stats.TooShort++
+			continue
+		}
+
+		playedAt, err := time.Parse(time.RFC3339, e.Timestamp)
+		if err != nil {
+			return fmt.Errorf("could not parse timestamp %q: %w", e.Timestamp, err)
+		}
+
+		key := listenKey{
+			trackID:  strings.TrimPrefix(e.SpotifyTrackURI, trackURIPrefix),
+			playedAt: playedAt.UTC(),
+		}
+		if im.seen[key] {
+			im.Stats.Duplicates++
+			continue
+		}
+		im.seen[key] = true
+
+		im.Stats.Accepted++
+		im.entries = append(im.entries, listens.BatchWriteListenEntry{
+			TrackID:    key.trackID,
+			PlayedAt:   key.playedAt,
+			DurationMs: e.MsPlayed,
+		})
+	}
+
+	return nil
+}
+
+// Entries returns the listens parsed so far.
+func (im *Importer) Entries() []listens.BatchWriteListenEntry {
+	return im.entries
+}
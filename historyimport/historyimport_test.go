@@ -0,0 +1,42 @@
+package historyimport
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const extendedHistory = `[
+	{"ts": "2021-08-20T12:00:00Z", "ms_played": 180000, "spotify_track_uri": "spotify:track:abc"},
+	{"ts": "2021-08-20T12:03:00Z", "ms_played": 4000, "spotify_track_uri": "spotify:track:def"},
+	{"ts": "2021-08-20T12:10:00Z", "ms_played": 900000, "spotify_track_uri": null, "spotify_episode_uri": "spotify:episode:xyz"},
+	{"ts": "2021-08-20T12:00:00Z", "ms_played": 180000, "spotify_track_uri": "spotify:track:abc"}
+]`
+
+func TestImporter(t *testing.T) {
+	im := New()
+	require.NoError(t, im.Add(strings.NewReader(extendedHistory)))
+	require.NoError(t, im.Add(strings.NewReader(`[{"endTime": "2021-08-20 12:00", "msPlayed": 180000, "trackName": "abc"}]`)))
+
+	assert.Equal(t, Stats{
+		Total:        5,
+		Accepted:     1,
+		NotTrack:     1,
+		TooShort:     1,
+		Duplicates:   1,
+		MissingTrack: 1,
+	}, im.Stats)
+
+	entries := im.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "abc", entries[0].TrackID)
+	assert.Equal(t, time.Date(2021, 8, 20, 12, 0, 0, 0, time.UTC), entries[0].PlayedAt)
+	assert.Equal(t, 180000, entries[0].DurationMs)
+}
+
+func TestImporterRejectsInvalidJSON(t *testing.T) {
+	assert.Error(t, New().Add(strings.NewReader(`{"not": "an array"}`)))
+}
@@ -0,0 +1,121 @@
+package historyscraper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"oscen/repositories/users"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// errBudgetSpent is returned for Spotify requests made once a run has used up
+// its request budget.
+var errBudgetSpent = errors.New("spotify request budget for this run is spent")
+
+// requestBudget counts the Spotify requests made during a run, refusing any
+// beyond its limit. A zero limit means no limit.
+type requestBudget struct {
+	mu        sync.Mutex
+	limit     int
+	remaining int
+}
+
+func newRequestBudget(limit int) *requestBudget {
+	return &requestBudget{limit: limit, remaining: limit}
+}
+
+// spend takes a request from the budget, reporting whether there was one left.
+func (b *requestBudget) spend() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.limit <= 0 {
+		return true
+	}
+	if b.remaining == 0 {
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+func (b *requestBudget) spent() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.limit > 0 && b.remaining == 0
+}
+
+// auth wraps the Spotify authenticator so clients it builds spend against the
+// budget, for both API requests and token refreshes.
+func (b *requestBudget) auth(auth users.SpotifyAuth) users.SpotifyAuth {
+	return &budgetAuth{budget: b, auth: auth}
+}
+
+type budgetAuth struct {
+	budget *requestBudget
+	auth   users.SpotifyAuth
+}
+
+func (a *budgetAuth) Client(ctx context.Context, token *oauth2.Token) *http.Client {
+	client := a.auth.Client(ctx, token)
+	t, ok := client.Transport.(*oauth2.Transport)
+	if !ok {
+		client.Transport = &budgetTransport{budget: a.budget, base: client.Transport}
+		return client
+	}
+
+	// The authenticator refreshes tokens with its own HTTP client, so the
+	// refresh is counted where the token source decides to make it.
+	t.Base = &budgetTransport{budget: a.budget, base: t.Base}
+	t.Source = &budgetTokenSource{budget: a.budget, src: t.Source, last: token}
+	return client
+}
+
+type budgetTransport struct {
+	budget *requestBudget
+	base   http.RoundTripper
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.budget.spend() {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, errBudgetSpent
+	}
+
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// budgetTokenSource spends a request whenever the token it last handed out
+// has expired, which is when the oauth2 token source it wraps refreshes it.
+type budgetTokenSource struct {
+	budget *requestBudget
+	src    oauth2.TokenSource
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func (ts *budgetTokenSource) Token() (*oauth2.Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if !ts.last.Valid() && !ts.budget.spend() {
+		return nil, errBudgetSpent
+	}
+
+	tok, err := ts.src.Token()
+	if err != nil {
+		return nil, err
+	}
+	ts.last = tok
+	return tok, nil
+}
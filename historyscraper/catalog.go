@@ -0,0 +1,97 @@
+package historyscraper
+
+import (
+	"context"
+	"oscen/repositories/catalog"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// CatalogRepository is the subset of the catalog repository used by the
+// scraper.
+type CatalogRepository interface {
+	GetUnknownTrackIDs(ctx context.Context, ids []string) ([]string, error)
+	UpsertTracks(ctx context.Context, tracks []catalog.Track) error
+}
+
+// updateCatalog stores the metadata of any tracks in items we haven't seen
+// before.
+func (hs *HistoryScraper) updateCatalog(
+	ctx context.Context,
+	client *spotify.Client,
+	items []spotify.RecentlyPlayedItem,
+) error {
+	if hs.CatalogRepo == nil {
+		return nil
+	}
+
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		// Local files don't have an ID, and Spotify rejects the whole
+		// lookup if any of the IDs are empty.
+		if item.Track.ID == "" {
+			continue
+		}
+		ids = append(ids, string(item.Track.ID))
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	unknown, err := hs.CatalogRepo.GetUnknownTrackIDs(ctx, ids)
+	if err != nil || len(unknown) == 0 {
+		return err
+	}
+
+	// Recently played items only carry simplified tracks which don't include
+	// the album, so look the full tracks up. There are never more than 50 of
+	// them, which is as many as Spotify will give us in one go.
+	spotifyIDs := make([]spotify.ID, 0, len(unknown))
+	for _, id := range unknown {
+		spotifyIDs = append(spotifyIDs, spotify.ID(id))
+	}
+	fullTracks, err := client.GetTracks(ctx, spotifyIDs)
+	if err != nil {
+		return err
+	}
+
+	tracks := make([]catalog.Track, 0, len(fullTracks))
+	for _, ft := range fullTracks {
+		if ft == nil {
+			continue
+		}
+		tracks = append(tracks, catalogTrack(ft))
+	}
+
+	return hs.CatalogRepo.UpsertTracks(ctx, tracks)
+}
+
+func catalogTrack(ft *spotify.FullTrack) catalog.Track {
+	track := catalog.Track{
+		ID:         string(ft.ID),
+		Name:       ft.Name,
+		DurationMs: ft.Duration,
+		Explicit:   ft.Explicit,
+	}
+
+	if ft.Album.ID != "" {
+		track.Album = &catalog.Album{
+			ID:          string(ft.Album.ID),
+			Name:        ft.Album.Name,
+			AlbumType:   ft.Album.AlbumType,
+			ReleaseDate: ft.Album.ReleaseDate,
+		}
+		if len(ft.Album.Images) > 0 {
+			track.Album.ImageURL = ft.Album.Images[0].URL
+		}
+	}
+
+	for _, a := range ft.Artists {
+		track.Artists = append(track.Artists, catalog.Artist{
+			ID:   string(a.ID),
+			Name: a.Name,
+		})
+	}
+
+	return track
+}
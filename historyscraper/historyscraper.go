@@ -2,9 +2,12 @@ package historyscraper
 
 import (
 	"context"
+	"errors"
+	"oscen/notifier"
 	"oscen/repositories/listens"
 	"oscen/repositories/users"
 	"oscen/tracer"
+	"sync"
 	"time"
 
 	"go.opentelemetry.io/otel/attribute"
@@ -16,12 +19,54 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	defaultMaxBackoff  = time.Hour
+	defaultConcurrency = 4
+)
+
+// ListensRepository is the subset of the listens repository used by the
+// scraper.
+type ListensRepository interface {
+	GetUsersLastListenTime(ctx context.Context, discordID string) (*time.Time, error)
+	BatchWriteListens(ctx context.Context, discordID string, entries []listens.BatchWriteListenEntry) (int64, error)
+}
+
+// UsersRepository is the subset of the users repository used by the scraper.
+type UsersRepository interface {
+	users.TokenStore
+	GetUsers(ctx context.Context) ([]users.User, error)
+	SetLinkStatus(ctx context.Context, discordID string, status users.LinkStatus) (bool, error)
+	RecordScrapeFailure(ctx context.Context, discordID string, nextAttempt time.Time, cause error) error
+	RecordScrapeSuccess(ctx context.Context, discordID string) error
+}
+
 type HistoryScraper struct {
 	Log         *zap.Logger
 	Auth        *spotifyauth.Authenticator
-	ListensRepo *listens.PostgresRepository
-	UsersRepo   *users.PostgresRepository
+	ListensRepo ListensRepository
+	UsersRepo   UsersRepository
+	// CatalogRepo stores track, album and artist metadata for the listens
+	// we scrape. If nil, metadata is not stored.
+	CatalogRepo CatalogRepository
 	Interval    time.Duration
+	// MaxBackoff caps how long a user who keeps failing to scrape is held
+	// off for. Defaults to an hour.
+	MaxBackoff time.Duration
+	// Concurrency is the number of users scraped at once. Defaults to 4.
+	Concurrency int
+	// MaxRequestsPerRun caps the Spotify requests, token refreshes included,
+	// made in a single run. Users it leaves out go first next run. Zero means
+	// no limit.
+	MaxRequestsPerRun int
+	// SpotifyOptions are applied to every Spotify client the scraper builds.
+	SpotifyOptions []spotify.ClientOption
+	// Notifier is used to DM users when their Spotify link is revoked. If
+	// nil, no notifications are sent.
+	Notifier *notifier.Notifier
+
+	// offset rotates the point in the user list we start from, so users
+	// deferred by MaxRequestsPerRun aren't starved.
+	offset int
 }
 
 func (hs *HistoryScraper) Run(ctx context.Context) {
@@ -30,11 +75,48 @@ func (hs *HistoryScraper) Run(ctx context.Context) {
 			return
 		}
 
-		if err := hs.RunOnce(ctx); err != nil {
+		if err := hs.RunOnce(ctx); err != nil && ctx.Err() == nil {
 			hs.Log.Error("failed to run history logger", zap.Error(err))
 		}
 
-		time.Sleep(hs.Interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(hs.Interval):
+		}
+	}
+}
+
+type scrapeOutcome int
+
+const (
+	outcomeSucceeded scrapeOutcome = iota
+	outcomeFailed
+	outcomeRevoked
+	outcomeBackingOff
+	outcomeDeferred
+)
+
+type scrapeSummary struct {
+	succeeded  int
+	failed     int
+	revoked    int
+	backingOff int
+	deferred   int
+}
+
+func (s *scrapeSummary) add(outcome scrapeOutcome) {
+	switch outcome {
+	case outcomeSucceeded:
+		s.succeeded++
+	case outcomeFailed:
+		s.failed++
+	case outcomeRevoked:
+		s.revoked++
+	case outcomeBackingOff:
+		s.backingOff++
+	case outcomeDeferred:
+		s.deferred++
 	}
 }
 
@@ -50,21 +132,157 @@ func (hs *HistoryScraper) RunOnce(ctx context.Context) error {
 		return err
 	}
 
-	for _, usr := range usrs {
-		if err := hs.ScrapeUser(ctx, &usr); err != nil {
-			return err
+	concurrency := hs.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	budget := newRequestBudget(hs.MaxRequestsPerRun)
+	work := make(chan scrapeJob)
+	outcomes := make(chan scrapeResult, len(usrs))
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range work {
+				outcomes <- scrapeResult{
+					position: job.position,
+					outcome:  hs.scrapeWithIsolation(ctx, budget, job.usr),
+				}
+			}
+		}()
+	}
+
+	first := 0
+	if len(usrs) > 0 {
+		first = hs.offset % len(usrs)
+	}
+	hs.dispatch(ctx, start, budget, usrs, first, work, outcomes)
+	close(work)
+	wg.Wait()
+	close(outcomes)
+
+	summary := scrapeSummary{}
+	firstDeferred := -1
+	for result := range outcomes {
+		summary.add(result.outcome)
+		if result.outcome == outcomeDeferred && (firstDeferred < 0 || result.position < firstDeferred) {
+			firstDeferred = result.position
 		}
 	}
+	// Pick up from the first user we didn't get to next time round.
+	if firstDeferred >= 0 {
+		hs.offset = (first + firstDeferred) % len(usrs)
+	}
 
+	childSpan.SetAttributes(
+		attribute.Int("io.oscen.scrape.user_count", len(usrs)),
+		attribute.Int("io.oscen.scrape.succeeded", summary.succeeded),
+		attribute.Int("io.oscen.scrape.failed", summary.failed),
+		attribute.Int("io.oscen.scrape.revoked", summary.revoked),
+		attribute.Int("io.oscen.scrape.backing_off", summary.backingOff),
+		attribute.Int("io.oscen.scrape.deferred", summary.deferred),
+	)
 	hs.Log.Info("finished scrape",
 		zap.Duration("duration", time.Since(start)),
 		zap.Int("user_count", len(usrs)),
+		zap.Int("succeeded", summary.succeeded),
+		zap.Int("failed", summary.failed),
+		zap.Int("revoked", summary.revoked),
+		zap.Int("backing_off", summary.backingOff),
+		zap.Int("deferred", summary.deferred),
 	)
 
-	return nil
+	return ctx.Err()
+}
+
+// scrapeJob is a user to scrape, along with their position in this run's
+// rotation of the user list.
+type scrapeJob struct {
+	position int
+	usr      *users.User
+}
+
+type scrapeResult struct {
+	position int
+	outcome  scrapeOutcome
+}
+
+// dispatch feeds users that are due a scrape to the workers, starting from
+// first, until the request budget is spent. Users that aren't sent to a
+// worker have their outcome reported directly.
+func (hs *HistoryScraper) dispatch(
+	ctx context.Context,
+	now time.Time,
+	budget *requestBudget,
+	usrs []users.User,
+	first int,
+	work chan<- scrapeJob,
+	outcomes chan<- scrapeResult,
+) {
+	for i := range usrs {
+		usr := &usrs[(first+i)%len(usrs)]
+
+		if usr.Status == users.LinkStatusRevoked {
+			hs.Log.Debug("skipping user with revoked link", zap.String("discord_user", usr.DiscordID))
+			outcomes <- scrapeResult{position: i, outcome: outcomeRevoked}
+			continue
+		}
+
+		if usr.NextScrapeAt != nil && now.Before(*usr.NextScrapeAt) {
+			hs.Log.Debug("skipping user in backoff",
+				zap.String("discord_user", usr.DiscordID),
+				zap.Time("next_scrape_at", *usr.NextScrapeAt),
+			)
+			outcomes <- scrapeResult{position: i, outcome: outcomeBackingOff}
+			continue
+		}
+
+		if budget.spent() {
+			outcomes <- scrapeResult{position: i, outcome: outcomeDeferred}
+			continue
+		}
+
+		select {
+		case work <- scrapeJob{position: i, usr: usr}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scrapeWithIsolation scrapes a single user, converting any failure into an
+// outcome. One broken account shouldn't stop everyone else being scraped.
+func (hs *HistoryScraper) scrapeWithIsolation(ctx context.Context, budget *requestBudget, usr *users.User) scrapeOutcome {
+	err := hs.scrapeUserWithBudget(ctx, budget, usr)
+	if err == nil {
+		return outcomeSucceeded
+	}
+
+	if errors.Is(err, errBudgetSpent) {
+		return outcomeDeferred
+	}
+
+	if users.IsRevokedGrant(err) {
+		return outcomeRevoked
+	}
+
+	hs.Log.Warn("failed to scrape user",
+		zap.Error(err),
+		zap.String("discord_user", usr.DiscordID),
+		zap.Int("consecutive_failures", usr.ScrapeFailures+1),
+	)
+	return outcomeFailed
 }
 
+// ScrapeUser scrapes a single user, without any limit on the Spotify requests
+// made.
 func (hs *HistoryScraper) ScrapeUser(ctx context.Context, user *users.User) error {
+	return hs.scrapeUserWithBudget(ctx, newRequestBudget(0), user)
+}
+
+func (hs *HistoryScraper) scrapeUserWithBudget(ctx context.Context, budget *requestBudget, user *users.User) error {
 	ctx, childSpan := tracer.Start(
 		ctx,
 		"historyscraper.scrape_user",
@@ -72,6 +290,106 @@ func (hs *HistoryScraper) ScrapeUser(ctx context.Context, user *users.User) erro
 	)
 	defer childSpan.End()
 
+	scrapeErr := hs.scrapeUser(ctx, budget, user)
+	if scrapeErr != nil {
+		childSpan.RecordError(scrapeErr)
+	}
+
+	// Running out of budget says nothing about the user's link, they'll be
+	// scraped next run.
+	if errors.Is(scrapeErr, errBudgetSpent) {
+		return scrapeErr
+	}
+
+	if err := hs.updateLinkStatus(ctx, user, scrapeErr); err != nil {
+		return err
+	}
+
+	if err := hs.updateBackoff(ctx, user, scrapeErr); err != nil {
+		return err
+	}
+
+	return scrapeErr
+}
+
+// updateLinkStatus records the outcome of a scrape against the user's link,
+// letting them know if we've lost access to their account.
+func (hs *HistoryScraper) updateLinkStatus(ctx context.Context, user *users.User, scrapeErr error) error {
+	status := users.LinkStatusActive
+	if users.IsRevokedGrant(scrapeErr) {
+		status = users.LinkStatusRevoked
+	} else if scrapeErr != nil {
+		status = users.LinkStatusErroring
+	}
+
+	if status == user.Status {
+		return nil
+	}
+
+	changed, err := hs.UsersRepo.SetLinkStatus(ctx, user.DiscordID, status)
+	if err != nil {
+		return err
+	}
+
+	if status != users.LinkStatusRevoked {
+		return nil
+	}
+
+	hs.Log.Warn("spotify link revoked", zap.String("discord_user", user.DiscordID))
+	if changed && hs.Notifier != nil {
+		if err := hs.Notifier.LinkRevoked(ctx, user.DiscordID); err != nil {
+			hs.Log.Warn("failed to notify user of revoked link",
+				zap.Error(err),
+				zap.String("discord_user", user.DiscordID),
+			)
+		}
+	}
+
+	return nil
+}
+
+// updateBackoff persists when a failing user should next be scraped, so the
+// backoff survives restarts.
+func (hs *HistoryScraper) updateBackoff(ctx context.Context, user *users.User, scrapeErr error) error {
+	if scrapeErr == nil {
+		if user.ScrapeFailures == 0 {
+			return nil
+		}
+		return hs.UsersRepo.RecordScrapeSuccess(ctx, user.DiscordID)
+	}
+
+	// Revoked users are skipped entirely until they register again.
+	if users.IsRevokedGrant(scrapeErr) {
+		return nil
+	}
+
+	maxBackoff := hs.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	delay := backoff(hs.Interval, maxBackoff, user.ScrapeFailures+1)
+
+	return hs.UsersRepo.RecordScrapeFailure(ctx, user.DiscordID, time.Now().Add(delay), scrapeErr)
+}
+
+// backoff returns how long to wait before retrying after the given number of
+// consecutive failures, doubling from base up to max.
+func backoff(base time.Duration, max time.Duration, failures int) time.Duration {
+	delay := base
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+func (hs *HistoryScraper) scrapeUser(ctx context.Context, budget *requestBudget, user *users.User) error {
 	hs.Log.Debug("scraping user", zap.String("discord_user", user.DiscordID))
 	start := time.Now()
 
@@ -80,7 +398,7 @@ func (hs *HistoryScraper) ScrapeUser(ctx context.Context, user *users.User) erro
 		return err
 	}
 
-	client := user.SpotifyClient(ctx, hs.Auth)
+	client := user.SpotifyClient(ctx, budget.auth(hs.Auth), hs.UsersRepo, hs.SpotifyOptions...)
 	var afterEpochMs int64 = 0
 	if lastPolled != nil {
 		afterEpochMs = (lastPolled.Add(time.Second).Unix()) * 1000
@@ -95,8 +413,16 @@ func (hs *HistoryScraper) ScrapeUser(ctx context.Context, user *users.User) erro
 		return err
 	}
 
-	// TODO: if we iterate from the end to the start (oldest to newest)
-	// we can get rid of the transactions/batching. Problem for another day :)
+	// Metadata is best effort, it shouldn't cost us the listens or put the
+	// user into backoff.
+	if err := hs.updateCatalog(ctx, client, rp); err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+		hs.Log.Warn("failed to update catalog",
+			zap.Error(err),
+			zap.String("discord_user", user.DiscordID),
+		)
+	}
+
 	batchWrite := make([]listens.BatchWriteListenEntry, 0, len(rp))
 	for _, rpi := range rp {
 		hs.Log.Debug("song played",
@@ -105,20 +431,28 @@ func (hs *HistoryScraper) ScrapeUser(ctx context.Context, user *users.User) erro
 		)
 
 		batchWrite = append(batchWrite, listens.BatchWriteListenEntry{
-			TrackID:  string(rpi.Track.ID),
-			PlayedAt: rpi.PlayedAt,
+			TrackID:     string(rpi.Track.ID),
+			PlayedAt:    rpi.PlayedAt,
+			ContextType: rpi.PlaybackContext.Type,
+			ContextURI:  string(rpi.PlaybackContext.URI),
+			DurationMs:  rpi.Track.Duration,
 		})
 	}
 
-	err = hs.ListensRepo.BatchWriteListens(ctx, user.DiscordID, batchWrite)
+	inserted, err := hs.ListensRepo.BatchWriteListens(ctx, user.DiscordID, batchWrite)
 	if err != nil {
 		return err
 	}
 
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("io.oscen.scrape.song_count", len(rp)),
+		attribute.Int64("io.oscen.scrape.inserted", inserted),
+	)
 	hs.Log.Info("scraped user",
 		zap.Duration("duration", time.Since(start)),
 		zap.String("discord_id", user.DiscordID),
 		zap.Int("song_count", len(rp)),
+		zap.Int64("inserted", inserted),
 	)
 
 	return nil
@@ -0,0 +1,319 @@
+package historyscraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"oscen/repositories/catalog"
+	"oscen/repositories/listens"
+	"oscen/repositories/users"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"go.uber.org/zap/zaptest"
+	"golang.org/x/oauth2"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: time.Minute},
+		{failures: 2, want: 2 * time.Minute},
+		{failures: 4, want: 8 * time.Minute},
+		{failures: 7, want: time.Hour},
+		{failures: 100, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, backoff(time.Minute, time.Hour, tt.failures), "failures: %d", tt.failures)
+	}
+}
+
+type listenKey struct {
+	trackID  string
+	playedAt time.Time
+}
+
+type fakeListensRepo struct {
+	mu              sync.Mutex
+	listens         map[string]map[listenKey]bool
+	duplicateWrites int
+}
+
+func newFakeListensRepo() *fakeListensRepo {
+	return &fakeListensRepo{listens: map[string]map[listenKey]bool{}}
+}
+
+func (r *fakeListensRepo) GetUsersLastListenTime(_ context.Context, discordID string) (*time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var last *time.Time
+	for k := range r.listens[discordID] {
+		k := k
+		if last == nil || k.playedAt.After(*last) {
+			last = &k.playedAt
+		}
+	}
+	return last, nil
+}
+
+func (r *fakeListensRepo) BatchWriteListens(_ context.Context, discordID string, entries []listens.BatchWriteListenEntry) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.listens[discordID] == nil {
+		r.listens[discordID] = map[listenKey]bool{}
+	}
+	inserted := int64(0)
+	for _, e := range entries {
+		k := listenKey{trackID: e.TrackID, playedAt: e.PlayedAt.UTC()}
+		if r.listens[discordID][k] {
+			r.duplicateWrites++
+			continue
+		}
+		r.listens[discordID][k] = true
+		inserted++
+	}
+	return inserted, nil
+}
+
+type fakeUsersRepo struct {
+	mu       sync.Mutex
+	users    []users.User
+	failures int
+}
+
+func (r *fakeUsersRepo) GetUsers(context.Context) ([]users.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]users.User{}, r.users...), nil
+}
+
+func (r *fakeUsersRepo) UpdateToken(context.Context, string, *oauth2.Token) error {
+	return nil
+}
+
+func (r *fakeUsersRepo) SetLinkStatus(context.Context, string, users.LinkStatus) (bool, error) {
+	return true, nil
+}
+
+func (r *fakeUsersRepo) RecordScrapeFailure(context.Context, string, time.Time, error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures++
+	return nil
+}
+
+func (r *fakeUsersRepo) RecordScrapeSuccess(context.Context, string) error {
+	return nil
+}
+
+// fakeCatalogRepo knows no tracks, so every lookup goes to Spotify.
+type fakeCatalogRepo struct {
+	mu     sync.Mutex
+	lookup [][]string
+}
+
+func (r *fakeCatalogRepo) GetUnknownTrackIDs(_ context.Context, ids []string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookup = append(r.lookup, ids)
+	return ids, nil
+}
+
+func (r *fakeCatalogRepo) UpsertTracks(context.Context, []catalog.Track) error {
+	return nil
+}
+
+// fakeSpotify serves the recently played endpoint from a fixed history per
+// access token, honouring the after parameter like Spotify does.
+type fakeSpotify struct {
+	*httptest.Server
+	history  map[string][]spotify.RecentlyPlayedItem
+	requests int64
+}
+
+func newFakeSpotify(t *testing.T, history map[string][]spotify.RecentlyPlayedItem) *fakeSpotify {
+	fs := &fakeSpotify{history: history}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fs.requests, 1)
+		if r.URL.Path != "/me/player/recently-played" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+
+		items := []spotify.RecentlyPlayedItem{}
+		for _, item := range fs.history[token] {
+			if item.PlayedAt.UnixNano()/int64(time.Millisecond) > after {
+				items = append(items, item)
+			}
+		}
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].PlayedAt.After(items[j].PlayedAt)
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(spotify.RecentlyPlayedResult{Items: items})
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(fs.Close)
+
+	return fs
+}
+
+func newTestScraper(t *testing.T, userCount int) (*HistoryScraper, *fakeListensRepo, *fakeSpotify) {
+	start := time.Date(2021, 8, 20, 12, 0, 0, 0, time.UTC)
+	history := map[string][]spotify.RecentlyPlayedItem{}
+	usersRepo := &fakeUsersRepo{}
+	for i := 0; i < userCount; i++ {
+		discordID := fmt.Sprintf("%d", 1000+i)
+		token := "token-" + discordID
+		for j := 0; j < 10; j++ {
+			history[token] = append(history[token], spotify.RecentlyPlayedItem{
+				Track:    spotify.SimpleTrack{ID: spotify.ID(fmt.Sprintf("track-%d", j%4))},
+				PlayedAt: start.Add(time.Duration(i+j*5) * time.Minute),
+			})
+		}
+		usersRepo.users = append(usersRepo.users, users.User{
+			DiscordID: discordID,
+			Status:    users.LinkStatusActive,
+			SpotifyToken: &oauth2.Token{
+				AccessToken: token,
+				TokenType:   "Bearer",
+				Expiry:      time.Now().Add(time.Hour),
+			},
+		})
+	}
+
+	fs := newFakeSpotify(t, history)
+	listensRepo := newFakeListensRepo()
+	hs := &HistoryScraper{
+		Log:            zaptest.NewLogger(t),
+		Auth:           spotifyauth.New(),
+		ListensRepo:    listensRepo,
+		UsersRepo:      usersRepo,
+		Interval:       time.Millisecond,
+		SpotifyOptions: []spotify.ClientOption{spotify.WithBaseURL(fs.URL + "/")},
+	}
+
+	return hs, listensRepo, fs
+}
+
+func TestRunOnceIsOrderIndependent(t *testing.T) {
+	var want map[string]map[listenKey]bool
+	for _, concurrency := range []int{1, 3, 16} {
+		hs, listensRepo, _ := newTestScraper(t, 20)
+		hs.Concurrency = concurrency
+		usersRepo := hs.UsersRepo.(*fakeUsersRepo)
+		rand.Shuffle(len(usersRepo.users), func(i, j int) {
+			usersRepo.users[i], usersRepo.users[j] = usersRepo.users[j], usersRepo.users[i]
+		})
+
+		// Running twice proves we only ever ask for listens we don't have.
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, hs.RunOnce(context.Background()))
+		}
+
+		assert.Equal(t, 0, listensRepo.duplicateWrites, "concurrency: %d", concurrency)
+		assert.Len(t, listensRepo.listens, 20)
+		for id, l := range listensRepo.listens {
+			assert.Len(t, l, 10, "user: %s", id)
+		}
+
+		if want == nil {
+			want = listensRepo.listens
+			continue
+		}
+		assert.Equal(t, want, listensRepo.listens, "concurrency: %d", concurrency)
+	}
+}
+
+func TestRunOnceMaxRequestsPerRun(t *testing.T) {
+	hs, listensRepo, fs := newTestScraper(t, 5)
+	// Workers racing for the budget would make who gets scraped random.
+	hs.Concurrency = 1
+	hs.MaxRequestsPerRun = 2
+
+	assert.NoError(t, hs.RunOnce(context.Background()))
+	assert.Len(t, listensRepo.listens, 2)
+	assert.EqualValues(t, 2, atomic.LoadInt64(&fs.requests))
+
+	assert.NoError(t, hs.RunOnce(context.Background()))
+	assert.Len(t, listensRepo.listens, 4)
+
+	assert.NoError(t, hs.RunOnce(context.Background()))
+	assert.Len(t, listensRepo.listens, 5)
+	assert.EqualValues(t, 6, atomic.LoadInt64(&fs.requests))
+	assert.Zero(t, hs.UsersRepo.(*fakeUsersRepo).failures)
+}
+
+func TestRunOnceMaxRequestsPerRunCountsRefreshes(t *testing.T) {
+	hs, listensRepo, fs := newTestScraper(t, 2)
+	hs.Concurrency = 1
+	hs.MaxRequestsPerRun = 1
+	// Refreshing this token would be the second request of the run.
+	usersRepo := hs.UsersRepo.(*fakeUsersRepo)
+	usersRepo.users[1].SpotifyToken.Expiry = time.Now().Add(-time.Hour)
+
+	assert.NoError(t, hs.RunOnce(context.Background()))
+
+	assert.Len(t, listensRepo.listens, 1)
+	assert.EqualValues(t, 1, atomic.LoadInt64(&fs.requests))
+	assert.Zero(t, usersRepo.failures)
+	assert.Equal(t, 1, hs.offset)
+}
+
+func TestScrapeUserCatalogIsBestEffort(t *testing.T) {
+	hs, listensRepo, fs := newTestScraper(t, 1)
+	catalogRepo := &fakeCatalogRepo{}
+	hs.CatalogRepo = catalogRepo
+	// A local file, which has no ID.
+	fs.history["token-1000"] = append(fs.history["token-1000"], spotify.RecentlyPlayedItem{
+		PlayedAt: time.Date(2021, 8, 21, 12, 0, 0, 0, time.UTC),
+	})
+
+	// The fake doesn't serve track lookups, so updating the catalog fails.
+	assert.NoError(t, hs.RunOnce(context.Background()))
+
+	assert.Len(t, listensRepo.listens["1000"], 11)
+	assert.Zero(t, hs.UsersRepo.(*fakeUsersRepo).failures)
+	if assert.Len(t, catalogRepo.lookup, 1) {
+		assert.NotContains(t, catalogRepo.lookup[0], "")
+		assert.Len(t, catalogRepo.lookup[0], 10)
+	}
+}
+
+func TestRunStopsOnContextCancel(t *testing.T) {
+	hs, _, _ := newTestScraper(t, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		hs.Run(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after context was cancelled")
+	}
+}
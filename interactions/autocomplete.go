@@ -0,0 +1,196 @@
+package interactions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"oscen/tracer"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Postcord/objects"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// Postcord doesn't know about autocomplete yet.
+const (
+	interactionAutocomplete    objects.InteractionType = 4
+	responseAutocompleteResult objects.ResponseType    = 8
+)
+
+const (
+	// defaultAutocompleteBudget is how long a handler has to come up with
+	// suggestions. Users are typing, so anything slower is useless.
+	defaultAutocompleteBudget = 1500 * time.Millisecond
+	autocompleteTTL           = time.Minute
+	// maxAutocompleteChoices is the most choices Discord will show.
+	maxAutocompleteChoices = 25
+)
+
+// autocompleteHandler suggests values for an option given what the user has
+// typed so far.
+type autocompleteHandler = func(
+	ctx context.Context,
+	interaction *objects.Interaction,
+	value string,
+) ([]objects.ApplicationCommandOptionChoice, error)
+
+type autocompleteResponse struct {
+	Type objects.ResponseType     `json:"type"`
+	Data autocompleteResponseData `json:"data"`
+}
+
+type autocompleteResponseData struct {
+	Choices []objects.ApplicationCommandOptionChoice `json:"choices"`
+}
+
+// autocompleteOption is an option in an autocomplete interaction, which
+// unlike a normal one says which option the user is typing in.
+type autocompleteOption struct {
+	Name    string                `json:"name"`
+	Value   interface{}           `json:"value"`
+	Focused bool                  `json:"focused"`
+	Options []*autocompleteOption `json:"options"`
+}
+
+// focusedOption finds the option being typed in, returning its name and path
+// (e.g. "stats artist name").
+func focusedOption(data []byte) (string, string, error) {
+	cmd := &autocompleteOption{}
+	if err := json.Unmarshal(data, cmd); err != nil {
+		return "", "", err
+	}
+
+	path := []string{cmd.Name}
+	opts := cmd.Options
+	for len(opts) > 0 {
+		var next []*autocompleteOption
+		for _, opt := range opts {
+			if opt.Focused {
+				return strings.Join(append(path, opt.Name), " "), fmt.Sprint(opt.Value), nil
+			}
+			if len(opt.Options) > 0 {
+				path = append(path, opt.Name)
+				next = opt.Options
+			}
+		}
+		opts = next
+	}
+
+	return "", "", fmt.Errorf("no option is focused")
+}
+
+// autocompleteCache holds recent suggestions, since each keystroke is a new
+// interaction and users often type the same prefixes.
+type autocompleteCache struct {
+	mu      sync.Mutex
+	entries map[string]autocompleteCacheEntry
+}
+
+type autocompleteCacheEntry struct {
+	choices []objects.ApplicationCommandOptionChoice
+	expires time.Time
+}
+
+func (c *autocompleteCache) get(key string, now time.Time) ([]objects.ApplicationCommandOptionChoice, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expires) {
+		return nil, false
+	}
+	return entry.choices, true
+}
+
+func (c *autocompleteCache) put(key string, choices []objects.ApplicationCommandOptionChoice, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[string]autocompleteCacheEntry{}
+	}
+	// Clear out anything that has expired rather than letting the cache
+	// grow forever.
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = autocompleteCacheEntry{choices: choices, expires: now.Add(autocompleteTTL)}
+}
+
+func (r *router) handleAutocomplete(ctx context.Context, interaction *objects.Interaction) (*autocompleteResponse, error) {
+	ctx, childSpan := tracer.Start(ctx, "interactions.handle_autocomplete")
+	defer childSpan.End()
+
+	path, value, err := focusedOption(interaction.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	childSpan.SetAttributes(
+		attribute.String("io.oscen.autocomplete_option", path),
+		attribute.String("io.oscen.discord_user", invokerOf(interaction).DiscordID()),
+	)
+
+	handler, ok := r.autocompleteRoutes[path]
+	if !ok {
+		return nil, fmt.Errorf("cannot find autocomplete handler for option: %s", path)
+	}
+
+	// Suggestions are usually based on the user's own listens.
+	key := fmt.Sprintf("%s\x00%d\x00%s", path, invokerOf(interaction).ID, strings.ToLower(value))
+	if choices, ok := r.autocompleteCache.get(key, time.Now()); ok {
+		childSpan.SetAttributes(attribute.Bool("io.oscen.autocomplete_cached", true))
+		return newAutocompleteResponse(choices), nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.autocompleteBudget)
+	defer cancel()
+
+	type result struct {
+		choices []objects.ApplicationCommandOptionChoice
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var choices []objects.ApplicationCommandOptionChoice
+		_, err := recovered(func() (interface{}, error) {
+			var err error
+			choices, err = handler(ctx, interaction, value)
+			return nil, err
+		})
+		done <- result{choices: choices, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return nil, res.err
+		}
+		if len(res.choices) > maxAutocompleteChoices {
+			res.choices = res.choices[:maxAutocompleteChoices]
+		}
+		r.autocompleteCache.put(key, res.choices, time.Now())
+		return newAutocompleteResponse(res.choices), nil
+	case <-ctx.Done():
+		// No suggestions is better than Discord showing an error.
+		childSpan.SetAttributes(attribute.Bool("io.oscen.autocomplete_timed_out", true))
+		r.log.Warn("autocomplete handler exceeded budget", zap.String("option", path))
+		return newAutocompleteResponse(nil), nil
+	}
+}
+
+func newAutocompleteResponse(choices []objects.ApplicationCommandOptionChoice) *autocompleteResponse {
+	if choices == nil {
+		choices = []objects.ApplicationCommandOptionChoice{}
+	}
+	return &autocompleteResponse{
+		Type: responseAutocompleteResult,
+		Data: autocompleteResponseData{Choices: choices},
+	}
+}
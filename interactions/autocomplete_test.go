@@ -0,0 +1,106 @@
+package interactions
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Postcord/objects"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestFocusedOption(t *testing.T) {
+	path, value, err := focusedOption([]byte(`{
+		"name": "stats",
+		"options": [{"type": 1, "name": "artist", "options": [
+			{"type": 3, "name": "period", "value": "week"},
+			{"type": 3, "name": "name", "value": "radio", "focused": true}
+		]}]
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, "stats artist name", path)
+	assert.Equal(t, "radio", value)
+
+	_, _, err = focusedOption([]byte(`{"name": "stats", "options": []}`))
+	assert.Error(t, err)
+}
+
+func autocompleteInteraction(value string) *objects.Interaction {
+	return &objects.Interaction{
+		Type:   interactionAutocomplete,
+		Member: &objects.GuildMember{User: &objects.User{ID: 1}},
+		Data:   []byte(`{"name": "test", "options": [{"type": 3, "name": "name", "value": "` + value + `", "focused": true}]}`),
+	}
+}
+
+func TestHandleAutocomplete(t *testing.T) {
+	rtr := NewRouter(zaptest.NewLogger(t), nil, nil)
+	rtr.autocompleteBudget = 50 * time.Millisecond
+
+	calls := 0
+	err := rtr.Register(&Interaction{
+		ApplicationCommand: &objects.ApplicationCommand{Name: "test"},
+		autocomplete: map[string]autocompleteHandler{
+			"name": func(ctx context.Context, interaction *objects.Interaction, value string) ([]objects.ApplicationCommandOptionChoice, error) {
+				calls++
+				if value == "slow" {
+					<-ctx.Done()
+					return nil, ctx.Err()
+				}
+				return []objects.ApplicationCommandOptionChoice{{Name: value, Value: value}}, nil
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	res, err := rtr.handleAutocomplete(context.Background(), autocompleteInteraction("rad"))
+	require.NoError(t, err)
+	assert.Equal(t, responseAutocompleteResult, res.Type)
+	assert.Equal(t, []objects.ApplicationCommandOptionChoice{{Name: "rad", Value: "rad"}}, res.Data.Choices)
+
+	// The same prefix again should be served from the cache.
+	_, err = rtr.handleAutocomplete(context.Background(), autocompleteInteraction("rad"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	res, err = rtr.handleAutocomplete(context.Background(), autocompleteInteraction("slow"))
+	require.NoError(t, err)
+	assert.Empty(t, res.Data.Choices)
+}
+
+func TestCommandPayloadMarksAutocomplete(t *testing.T) {
+	i := &Interaction{
+		ApplicationCommand: &objects.ApplicationCommand{
+			Name: "stats",
+			Options: []objects.ApplicationCommandOption{
+				{
+					OptionType: objects.TypeSubCommand,
+					Name:       "artist",
+					Options: []objects.ApplicationCommandOption{
+						{OptionType: objects.TypeString, Name: "name"},
+						{OptionType: objects.TypeString, Name: "period"},
+					},
+				},
+			},
+		},
+		autocomplete: map[string]autocompleteHandler{"artist name": nil},
+	}
+
+	payload, err := commandPayload(i, true)
+	require.NoError(t, err)
+
+	cmd := struct {
+		Options []struct {
+			Options []struct {
+				Name         string `json:"name"`
+				Autocomplete bool   `json:"autocomplete"`
+			} `json:"options"`
+		} `json:"options"`
+	}{}
+	require.NoError(t, json.Unmarshal(payload, &cmd))
+	assert.True(t, cmd.Options[0].Options[0].Autocomplete)
+	assert.False(t, cmd.Options[0].Options[1].Autocomplete)
+}
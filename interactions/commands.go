@@ -0,0 +1,35 @@
+package interactions
+
+import (
+	"oscen/playlistcreator"
+	"oscen/repositories/catalog"
+	"oscen/repositories/guildmembers"
+	"oscen/repositories/listens"
+	"oscen/repositories/users"
+)
+
+// Dependencies are what the commands need to run. Commands can be built from
+// the zero value when only their definitions are needed, e.g. to sync them.
+type Dependencies struct {
+	UsersRepo       users.Repository
+	ListensRepo     listens.Repository
+	MembersRepo     guildmembers.Repository
+	CatalogRepo     catalog.Repository
+	Auth            spotifyAuth
+	PlaylistCreator *playlistcreator.PlaylistCreator
+}
+
+// Commands returns every command Oscen provides.
+func Commands(deps Dependencies) []*Interaction {
+	return []*Interaction{
+		NewNowPlayingInteraction(deps.UsersRepo, deps.Auth, deps.ListensRepo),
+		NewListenLeaderboardInteraction(deps.ListensRepo, deps.MembersRepo),
+		NewRegisterInteraction(deps.Auth),
+		NewGenerateInteraction(deps.UsersRepo, deps.Auth, deps.PlaylistCreator),
+		NewCustomGenerateInteraction(deps.UsersRepo, deps.Auth, deps.PlaylistCreator),
+		NewImportHistoryInteraction(deps.ListensRepo),
+		NewStatsInteraction(deps.ListensRepo, deps.CatalogRepo),
+		NewListeningToInteraction(deps.UsersRepo, deps.Auth),
+		NewCompareTasteInteraction(deps.UsersRepo, deps.Auth),
+	}
+}
@@ -0,0 +1,162 @@
+package interactions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Postcord/objects"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const discordAPI = "https://discord.com/api/v10"
+
+// CommandsClient manages application commands through the Discord API. We
+// can't use rest.Client for this as objects.ApplicationCommand is missing
+// fields we need, such as autocomplete.
+type CommandsClient struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+func NewCommandsClient(token string) *CommandsClient {
+	return &CommandsClient{
+		BaseURL: discordAPI,
+		Token:   token,
+		HTTP: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+	}
+}
+
+func (c *CommandsClient) commandsPath(appID objects.Snowflake, guildID *objects.Snowflake) string {
+	if guildID != nil {
+		return fmt.Sprintf("/applications/%d/guilds/%d/commands", appID, *guildID)
+	}
+	return fmt.Sprintf("/applications/%d/commands", appID)
+}
+
+// ApplicationID returns the ID of the application the token belongs to.
+func (c *CommandsClient) ApplicationID(ctx context.Context) (objects.Snowflake, error) {
+	app := struct {
+		ID objects.Snowflake `json:"id"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/oauth2/applications/@me", nil, &app); err != nil {
+		return 0, err
+	}
+	return app.ID, nil
+}
+
+// GetCommands returns the commands currently registered, globally if guildID
+// is nil.
+func (c *CommandsClient) GetCommands(
+	ctx context.Context,
+	appID objects.Snowflake,
+	guildID *objects.Snowflake,
+) ([]json.RawMessage, error) {
+	cmds := []json.RawMessage{}
+	if err := c.do(ctx, http.MethodGet, c.commandsPath(appID, guildID), nil, &cmds); err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
+
+// BulkOverwriteCommands replaces all the registered commands with cmds,
+// deleting any that aren't included.
+func (c *CommandsClient) BulkOverwriteCommands(
+	ctx context.Context,
+	appID objects.Snowflake,
+	guildID *objects.Snowflake,
+	cmds []json.RawMessage,
+) error {
+	return c.do(ctx, http.MethodPut, c.commandsPath(appID, guildID), cmds, nil)
+}
+
+func (c *CommandsClient) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		reqBody, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", c.Token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "oscen")
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("discord returned %d for %s %s: %s", res.StatusCode, method, path, resBody)
+	}
+
+	if out != nil {
+		return json.Unmarshal(resBody, out)
+	}
+	return nil
+}
+
+// commandPayload builds the JSON Discord expects for the command, including
+// the fields objects.ApplicationCommand can't express. Only global commands
+// can be used in DMs, so dm_permission is left out of guild ones.
+func commandPayload(i *Interaction, global bool) (json.RawMessage, error) {
+	raw, err := json.Marshal(i.ApplicationCommand)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return nil, err
+	}
+	if global {
+		cmd["dm_permission"] = i.dmCapable
+	}
+
+	for path := range i.autocomplete {
+		opt := findPayloadOption(cmd, strings.Split(path, " "))
+		if opt == nil {
+			return nil, fmt.Errorf("autocomplete option %q not found in command %s", path, i.Name)
+		}
+		opt["autocomplete"] = true
+		delete(opt, "choices")
+	}
+
+	return json.Marshal(cmd)
+}
+
+func findPayloadOption(parent map[string]interface{}, path []string) map[string]interface{} {
+	opts, _ := parent["options"].([]interface{})
+	for _, o := range opts {
+		opt, ok := o.(map[string]interface{})
+		if !ok || opt["name"] != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return opt
+		}
+		return findPayloadOption(opt, path[1:])
+	}
+	return nil
+}
@@ -0,0 +1,509 @@
+package interactions_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"oscen/interactions"
+	"oscen/interactions/interactionstest"
+	"oscen/repositories/catalog"
+	"oscen/repositories/listens"
+	"testing"
+	"time"
+
+	"github.com/Postcord/objects"
+	"github.com/stretchr/testify/require"
+	"github.com/zmb3/spotify/v2"
+)
+
+// friend is another member of the harness guild.
+var friend = &objects.User{ID: 201, Username: "friend", Discriminator: "0002"}
+
+func newHarness(t *testing.T) *interactionstest.Harness {
+	h := interactionstest.New(t)
+	h.AddMember(friend)
+	h.Register(interactions.Commands(h.Dependencies())...)
+	return h
+}
+
+func track(id, name, artist string) spotify.FullTrack {
+	return spotify.FullTrack{SimpleTrack: spotify.SimpleTrack{
+		ID:      spotify.ID(id),
+		Name:    name,
+		Artists: []spotify.SimpleArtist{{Name: artist}},
+	}}
+}
+
+func artists(names ...string) []spotify.FullArtist {
+	res := []spotify.FullArtist{}
+	for _, name := range names {
+		res = append(res, spotify.FullArtist{SimpleArtist: spotify.SimpleArtist{Name: name}})
+	}
+	return res
+}
+
+// targetFriend is the data for a user command used on friend.
+func targetFriend(name string) string {
+	return fmt.Sprintf(`{
+		"name": %q,
+		"type": 2,
+		"target_id": "%d",
+		"resolved": {"users": {"%d": {"id": "%d", "username": "friend"}}}
+	}`, name, friend.ID, friend.ID, friend.ID)
+}
+
+// forgetMembers leaves the harness guild as it is before its members have
+// been synced.
+func forgetMembers(t *testing.T, h *interactionstest.Harness) {
+	err := h.Members.RemoveGuild(context.Background(), fmt.Sprintf("%d", h.Guild.ID))
+	require.NoError(t, err)
+}
+
+// listenToCatalog adds some tracks to the catalog, and has the harness user
+// listen to them.
+func listenToCatalog(t *testing.T, h *interactionstest.Harness) {
+	boc := catalog.Artist{ID: "boc", Name: "Boards of Canada"}
+	aphex := catalog.Artist{ID: "aphex", Name: "Aphex Twin"}
+	err := h.Catalog.UpsertTracks(context.Background(), []catalog.Track{
+		{ID: "roygbiv", Name: "Roygbiv", Artists: []catalog.Artist{boc}},
+		{ID: "olson", Name: "Olson", Artists: []catalog.Artist{boc}},
+		{ID: "xtal", Name: "Xtal", Artists: []catalog.Artist{aphex}},
+	})
+	require.NoError(t, err)
+
+	entries := []listens.BatchWriteListenEntry{}
+	for i, id := range []string{"roygbiv", "olson", "roygbiv", "xtal"} {
+		entries = append(entries, listens.BatchWriteListenEntry{
+			TrackID:  id,
+			PlayedAt: time.Now().Add(-time.Duration(i+1) * time.Hour),
+		})
+	}
+	_, err = h.Listens.BatchWriteListens(context.Background(), "200", entries)
+	require.NoError(t, err)
+}
+
+const extendedHistory = `[
+	{"ts": "2021-08-20T12:00:00Z", "ms_played": 180000, "spotify_track_uri": "spotify:track:abc"},
+	{"ts": "2021-08-20T12:03:00Z", "ms_played": 4000, "spotify_track_uri": "spotify:track:def"}
+]`
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(t *testing.T, h *interactionstest.Harness)
+		interaction func(h *interactionstest.Harness) *objects.Interaction
+	}{
+		{
+			name: "ping",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(objects.InteractionRequestPing, "")
+			},
+		},
+		{
+			name: "register",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "register", "type": 1}`)
+			},
+		},
+		{
+			name: "register in DM",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return interactionstest.InDM(h.Command(`{"name": "register", "type": 1}`))
+			},
+		},
+		{
+			name: "np not registered",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "np", "type": 1}`)
+			},
+		},
+		{
+			name: "np in DM not registered",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return interactionstest.InDM(h.Command(`{"name": "np", "type": 1}`))
+			},
+		},
+		{
+			name: "np in DM",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				h.LinkSpotify(h.User, &interactionstest.SpotifyUser{ID: "tester"})
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return interactionstest.InDM(h.Command(`{"name": "np", "type": 1}`))
+			},
+		},
+		{
+			name: "np nothing playing",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				h.LinkSpotify(h.User, &interactionstest.SpotifyUser{ID: "tester"})
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "np", "type": 1}`)
+			},
+		},
+		{
+			name: "np",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				playing := track("roygbiv", "Roygbiv", "Boards of Canada")
+				h.LinkSpotify(h.User, &interactionstest.SpotifyUser{
+					ID:         "tester",
+					NowPlaying: &spotify.CurrentlyPlaying{Playing: true, Item: &playing},
+				})
+				_, err := h.Listens.BatchWriteListens(context.Background(), "200", []listens.BatchWriteListenEntry{
+					{TrackID: "roygbiv", PlayedAt: time.Now().Add(-time.Hour)},
+					{TrackID: "roygbiv", PlayedAt: time.Now().Add(-2 * time.Hour)},
+					{TrackID: "olson", PlayedAt: time.Now().Add(-3 * time.Hour)},
+				})
+				require.NoError(t, err)
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "np", "type": 1}`)
+			},
+		},
+		{
+			name: "generate",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				h.LinkSpotify(h.User, &interactionstest.SpotifyUser{
+					ID:        "tester",
+					TopTracks: []spotify.FullTrack{track("1", "Roygbiv", "Boards of Canada")},
+				})
+				h.LinkSpotify(friend, &interactionstest.SpotifyUser{
+					ID:        "friend",
+					TopTracks: []spotify.FullTrack{track("2", "Xtal", "Aphex Twin")},
+				})
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "generate", "type": 1}`)
+			},
+		},
+		{
+			name: "generate not registered",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "generate", "type": 1}`)
+			},
+		},
+		{
+			name: "generate members not synced",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				h.LinkSpotify(h.User, &interactionstest.SpotifyUser{
+					ID:        "tester",
+					TopTracks: []spotify.FullTrack{track("1", "Roygbiv", "Boards of Canada")},
+				})
+				forgetMembers(t, h)
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "generate", "type": 1}`)
+			},
+		},
+		{
+			name: "generate in DM",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return interactionstest.InDM(h.Command(`{"name": "generate", "type": 1}`))
+			},
+		},
+		{
+			name: "generate regenerate",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				h.LinkSpotify(h.User, &interactionstest.SpotifyUser{
+					ID:        "tester",
+					TopTracks: []spotify.FullTrack{track("1", "Roygbiv", "Boards of Canada")},
+				})
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(objects.InteractionButton, `{"custom_id": "generate.regenerate", "component_type": 2}`)
+			},
+		},
+		{
+			name: "generate-custom",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "generate-custom", "type": 1}`)
+			},
+		},
+		{
+			name: "generate-custom submit",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				h.LinkSpotify(h.User, &interactionstest.SpotifyUser{
+					ID: "tester",
+					TopTracks: []spotify.FullTrack{
+						track("1", "Roygbiv", "Boards of Canada"),
+						track("2", "Olson", "Boards of Canada"),
+					},
+				})
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(5, `{
+					"custom_id": "generate.custom",
+					"components": [
+						{"type": 1, "components": [{"type": 4, "custom_id": "name", "value": "Road trip"}]},
+						{"type": 1, "components": [{"type": 4, "custom_id": "size", "value": "1"}]},
+						{"type": 1, "components": [{"type": 4, "custom_id": "time_range", "value": "long"}]},
+						{"type": 1, "components": [{"type": 4, "custom_id": "visibility", "value": "private"}]}
+					]
+				}`)
+			},
+		},
+		{
+			name: "generate-custom submit private linked before scope",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				h.LinkSpotify(h.User, &interactionstest.SpotifyUser{
+					ID:                  "tester",
+					TopTracks:           []spotify.FullTrack{track("1", "Roygbiv", "Boards of Canada")},
+					PublicPlaylistsOnly: true,
+				})
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(5, `{
+					"custom_id": "generate.custom",
+					"components": [
+						{"type": 1, "components": [{"type": 4, "custom_id": "visibility", "value": "private"}]}
+					]
+				}`)
+			},
+		},
+		{
+			name: "generate-custom invalid size",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(5, `{
+					"custom_id": "generate.custom",
+					"components": [
+						{"type": 1, "components": [{"type": 4, "custom_id": "size", "value": "lots"}]}
+					]
+				}`)
+			},
+		},
+		{
+			name: "stats listening-time",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				_, err := h.Listens.BatchWriteListens(context.Background(), "200", []listens.BatchWriteListenEntry{
+					{TrackID: "1", PlayedAt: time.Now().Add(-time.Hour), ContextType: "album", ContextURI: "spotify:album:1", DurationMs: 600000},
+					{TrackID: "2", PlayedAt: time.Now().Add(-2 * time.Hour), DurationMs: 300000},
+					{TrackID: "3", PlayedAt: time.Now().Add(-30 * 24 * time.Hour), DurationMs: 300000},
+				})
+				require.NoError(t, err)
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{
+					"name": "stats",
+					"type": 1,
+					"options": [{"name": "listening-time", "type": 1, "options": [{"name": "period", "type": 3, "value": "week"}]}]
+				}`)
+			},
+		},
+		{
+			name:  "stats top-artists",
+			setup: listenToCatalog,
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "stats", "type": 1, "options": [{"name": "top-artists", "type": 1, "options": []}]}`)
+			},
+		},
+		{
+			name:  "stats top-tracks",
+			setup: listenToCatalog,
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{
+					"name": "stats",
+					"type": 1,
+					"options": [{"name": "top-tracks", "type": 1, "options": [{"name": "count", "type": 4, "value": 2}]}]
+				}`)
+			},
+		},
+		{
+			name:  "stats artist",
+			setup: listenToCatalog,
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{
+					"name": "stats",
+					"type": 1,
+					"options": [{"name": "artist", "type": 1, "options": [{"name": "name", "type": 3, "value": "boc"}]}]
+				}`)
+			},
+		},
+		{
+			name:  "stats artist unknown",
+			setup: listenToCatalog,
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{
+					"name": "stats",
+					"type": 1,
+					"options": [{"name": "artist", "type": 1, "options": [{"name": "name", "type": 3, "value": "Boards"}]}]
+				}`)
+			},
+		},
+		{
+			name:  "stats artist autocomplete",
+			setup: listenToCatalog,
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(4, `{
+					"name": "stats",
+					"type": 1,
+					"options": [{"name": "artist", "type": 1, "options": [{"name": "name", "type": 3, "value": "b", "focused": true}]}]
+				}`)
+			},
+		},
+		{
+			name: "stats artist without name",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{
+					"name": "stats",
+					"type": 1,
+					"options": [{"name": "artist", "type": 1, "options": []}]
+				}`)
+			},
+		},
+		{
+			name: "stats change period",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(objects.InteractionButton, `{
+					"custom_id": "stats.period:200:listening-time:201:10:",
+					"component_type": 3,
+					"values": ["all"]
+				}`)
+			},
+		},
+		{
+			name: "stats change period not owner",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(objects.InteractionButton, `{
+					"custom_id": "stats.period:201:listening-time:201:10:",
+					"component_type": 3,
+					"values": ["all"]
+				}`)
+			},
+		},
+		{
+			name: "leaderboard",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				for discordID, plays := range map[string]int{"200": 2, "201": 3, "300": 5} {
+					entries := []listens.BatchWriteListenEntry{}
+					for i := 0; i < plays; i++ {
+						entries = append(entries, listens.BatchWriteListenEntry{
+							TrackID:    fmt.Sprintf("%d", i),
+							PlayedAt:   time.Now().Add(-time.Duration(i+1) * time.Hour),
+							DurationMs: 180000,
+						})
+					}
+					_, err := h.Listens.BatchWriteListens(context.Background(), discordID, entries)
+					require.NoError(t, err)
+				}
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "leaderboard", "type": 1, "options": [{"name": "period", "type": 3, "value": "day"}]}`)
+			},
+		},
+		{
+			name: "leaderboard empty",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "leaderboard", "type": 1}`)
+			},
+		},
+		{
+			name: "leaderboard members not synced",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				forgetMembers(t, h)
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "leaderboard", "type": 1}`)
+			},
+		},
+		{
+			name: "leaderboard next page",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				for i := 0; i < 12; i++ {
+					member := &objects.User{ID: objects.Snowflake(300 + i), Username: fmt.Sprintf("member%d", i)}
+					h.AddMember(member)
+					_, err := h.Listens.BatchWriteListens(context.Background(), fmt.Sprintf("%d", member.ID), []listens.BatchWriteListenEntry{
+						{TrackID: "1", PlayedAt: time.Now().Add(-time.Hour), DurationMs: 180000},
+					})
+					require.NoError(t, err)
+				}
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(objects.InteractionButton, `{"custom_id": "leaderboard.page:200:all:1", "component_type": 2}`)
+			},
+		},
+		{
+			name: "leaderboard next page not owner",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(objects.InteractionButton, `{"custom_id": "leaderboard.page:201:all:1", "component_type": 2}`)
+			},
+		},
+		{
+			name: "leaderboard in DM",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return interactionstest.InDM(h.Command(`{"name": "leaderboard", "type": 1}`))
+			},
+		},
+		{
+			name: "import history",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write([]byte(extendedHistory))
+				}))
+				t.Cleanup(cdn.Close)
+
+				return h.Interaction(objects.InteractionApplicationCommand, fmt.Sprintf(`{
+					"name": "Import Spotify history",
+					"type": 3,
+					"target_id": "500",
+					"resolved": {"messages": {"500": {
+						"id": "500",
+						"author": {"id": "200", "username": "tester"},
+						"attachments": [{"id": "501", "filename": "endsong_0.json", "size": %d, "url": "%s/endsong_0.json"}]
+					}}}
+				}`, len(extendedHistory), cdn.URL))
+			},
+		},
+		{
+			name: "What are they listening to",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				playing := track("xtal", "Xtal", "Aphex Twin")
+				h.LinkSpotify(friend, &interactionstest.SpotifyUser{
+					ID:         "friend",
+					NowPlaying: &spotify.CurrentlyPlaying{Playing: true, Item: &playing},
+				})
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(objects.InteractionApplicationCommand, targetFriend("What are they listening to?"))
+			},
+		},
+		{
+			name: "What are they listening to not registered",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(objects.InteractionApplicationCommand, targetFriend("What are they listening to?"))
+			},
+		},
+		{
+			name: "Compare taste",
+			setup: func(t *testing.T, h *interactionstest.Harness) {
+				h.LinkSpotify(h.User, &interactionstest.SpotifyUser{
+					ID:         "tester",
+					TopArtists: artists("Boards of Canada", "Aphex Twin", "Autechre"),
+				})
+				h.LinkSpotify(friend, &interactionstest.SpotifyUser{
+					ID:         "friend",
+					TopArtists: artists("Aphex Twin", "Burial", "Boards of Canada"),
+				})
+			},
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Interaction(objects.InteractionApplicationCommand, targetFriend("Compare taste"))
+			},
+		},
+		{
+			name: "unknown command",
+			interaction: func(h *interactionstest.Harness) *objects.Interaction {
+				return h.Command(`{"name": "not-a-command", "type": 1}`)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			if tt.setup != nil {
+				tt.setup(t, h)
+			}
+			interactionstest.AssertGolden(t, h.Send(tt.interaction(h)))
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package interactions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"oscen/tracer"
+	"strings"
+
+	"github.com/Postcord/objects"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// customIDSeparator separates the component name from its state in a
+// custom_id. State values are query escaped so they can't contain it.
+const customIDSeparator = ":"
+
+// maxCustomIDLength is the longest custom_id Discord accepts.
+const maxCustomIDLength = 100
+
+// errCustomIDTooLong is returned by customID when the state doesn't fit.
+var errCustomIDTooLong = errors.New("custom_id is too long")
+
+type componentHandler = func(
+	ctx context.Context,
+	interaction *objects.Interaction,
+	componentData *objects.ApplicationComponentInteractionData,
+	state []string,
+) (*objects.InteractionResponse, error)
+
+// Component handles interactions with buttons and select menus whose
+// custom_id was built with customID using the same name.
+type Component struct {
+	Name     string
+	handler  componentHandler
+	deferred bool
+	// owned components can only be used by their owner, e.g. whoever used
+	// the command they are attached to. Their custom_id must be built with
+	// ownedCustomID, and the owner isn't passed on to the handler.
+	owned bool
+}
+
+// customID builds the custom_id for a component, encoding the state needed
+// to handle it since we don't keep any on our side. State can come from
+// users, so may not fit.
+func customID(name string, state ...string) (string, error) {
+	parts := []string{name}
+	for _, s := range state {
+		parts = append(parts, url.QueryEscape(s))
+	}
+
+	id := strings.Join(parts, customIDSeparator)
+	if len(id) > maxCustomIDLength {
+		return "", fmt.Errorf("%w: %s has %d characters", errCustomIDTooLong, name, len(id))
+	}
+	return id, nil
+}
+
+// ownedCustomID is customID for an owned component, owned by the user with
+// the Discord ID owner.
+func ownedCustomID(owner string, name string, state ...string) (string, error) {
+	return customID(name, append([]string{owner}, state...)...)
+}
+
+// ownedComponentHandler stops anyone but the owner of a component using it.
+func ownedComponentHandler(h componentHandler) componentHandler {
+	return func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		componentData *objects.ApplicationComponentInteractionData,
+		state []string,
+	) (*objects.InteractionResponse, error) {
+		if len(state) == 0 {
+			return nil, fmt.Errorf("missing owner of component: %s", componentData.CustomID)
+		}
+		if state[0] != invokerOf(interaction).DiscordID() {
+			return ephemeralResponse("Only whoever used the command can use this"), nil
+		}
+
+		return h(ctx, interaction, componentData, state[1:])
+	}
+}
+
+func parseCustomID(id string) (string, []string, error) {
+	parts := strings.Split(id, customIDSeparator)
+
+	state := []string{}
+	for _, p := range parts[1:] {
+		s, err := url.QueryUnescape(p)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid custom_id %q: %w", id, err)
+		}
+		state = append(state, s)
+	}
+
+	return parts[0], state, nil
+}
+
+func (r *router) handleComponent(ctx context.Context, interaction *objects.Interaction) (*objects.InteractionResponse, error) {
+	r.log.Debug("interaction.handle_component", zap.Any("data", interaction))
+	ctx, childSpan := tracer.Start(ctx, "interactions.handle_component")
+	defer childSpan.End()
+
+	componentData := &objects.ApplicationComponentInteractionData{}
+	err := json.Unmarshal(interaction.Data, componentData)
+	if err != nil {
+		return nil, err
+	}
+
+	name, state, err := parseCustomID(componentData.CustomID)
+	if err != nil {
+		return nil, err
+	}
+
+	childSpan.SetAttributes(
+		attribute.String("io.oscen.component_name", name),
+		attribute.String("io.oscen.discord_user", invokerOf(interaction).DiscordID()),
+	)
+
+	handler, ok := r.componentRoutes[name]
+	if !ok {
+		return nil, fmt.Errorf(
+			"cannot find handler for component: %s", name,
+		)
+	}
+
+	return handler(ctx, interaction, componentData, state)
+}
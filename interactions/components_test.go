@@ -0,0 +1,129 @@
+package interactions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Postcord/objects"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestCustomIDRoundTrip(t *testing.T) {
+	id, err := customID("stats.period", "top-artists", "a:b c", "10")
+	require.NoError(t, err)
+	assert.Equal(t, "stats.period:top-artists:a%3Ab+c:10", id)
+
+	name, state, err := parseCustomID(id)
+	require.NoError(t, err)
+	assert.Equal(t, "stats.period", name)
+	assert.Equal(t, []string{"top-artists", "a:b c", "10"}, state)
+
+	name, state, err = parseCustomID("generate.regenerate")
+	require.NoError(t, err)
+	assert.Equal(t, "generate.regenerate", name)
+	assert.Empty(t, state)
+}
+
+func TestCustomIDTooLong(t *testing.T) {
+	_, err := customID("stats.period", strings.Repeat("a", 90))
+	assert.ErrorIs(t, err, errCustomIDTooLong)
+
+	// Escaping counts towards the limit.
+	_, err = customID("stats.period", strings.Repeat(":", 30))
+	assert.ErrorIs(t, err, errCustomIDTooLong)
+}
+
+func TestOwnedComponent(t *testing.T) {
+	rtr := NewRouter(zaptest.NewLogger(t), nil, nil)
+
+	var gotState []string
+	err := rtr.Register(&Interaction{
+		ApplicationCommand: &objects.ApplicationCommand{Name: "test"},
+		components: []*Component{
+			{
+				Name:  "test.owned",
+				owned: true,
+				handler: func(
+					ctx context.Context,
+					interaction *objects.Interaction,
+					componentData *objects.ApplicationComponentInteractionData,
+					state []string,
+				) (*objects.InteractionResponse, error) {
+					gotState = state
+					return &objects.InteractionResponse{Type: objects.ResponseUpdateMessage}, nil
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	id, err := ownedCustomID("1", "test.owned", "a")
+	require.NoError(t, err)
+	click := func(userID objects.Snowflake) *objects.InteractionResponse {
+		res, err := rtr.handleComponent(context.Background(), &objects.Interaction{
+			Type:   objects.InteractionButton,
+			Member: &objects.GuildMember{User: &objects.User{ID: userID}},
+			Data:   []byte(fmt.Sprintf(`{"custom_id": %q, "component_type": 2}`, id)),
+		})
+		require.NoError(t, err)
+		return res
+	}
+
+	// Anyone else is turned away without running the handler.
+	res := click(2)
+	assert.Equal(t, objects.ResponseChannelMessageWithSource, res.Type)
+	assert.Equal(t, objects.ResponseFlagEphemeral, res.Data.Flags)
+	assert.Nil(t, gotState)
+
+	// The owner isn't passed on.
+	res = click(1)
+	assert.Equal(t, objects.ResponseUpdateMessage, res.Type)
+	assert.Equal(t, []string{"a"}, gotState)
+}
+
+func TestHandleComponent(t *testing.T) {
+	rtr := NewRouter(zaptest.NewLogger(t), nil, nil)
+
+	var gotState []string
+	var gotValues []string
+	err := rtr.Register(&Interaction{
+		ApplicationCommand: &objects.ApplicationCommand{Name: "test"},
+		components: []*Component{
+			{
+				Name: "test.menu",
+				handler: func(
+					ctx context.Context,
+					interaction *objects.Interaction,
+					componentData *objects.ApplicationComponentInteractionData,
+					state []string,
+				) (*objects.InteractionResponse, error) {
+					gotState = state
+					gotValues = componentData.Values
+					return &objects.InteractionResponse{Type: objects.ResponseUpdateMessage}, nil
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	res, err := rtr.handleComponent(context.Background(), &objects.Interaction{
+		Type:   objects.InteractionButton,
+		Member: &objects.GuildMember{User: &objects.User{ID: 1}},
+		Data:   []byte(`{"custom_id": "test.menu:a:b", "component_type": 3, "values": ["week"]}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, objects.ResponseUpdateMessage, res.Type)
+	assert.Equal(t, []string{"a", "b"}, gotState)
+	assert.Equal(t, []string{"week"}, gotValues)
+
+	_, err = rtr.handleComponent(context.Background(), &objects.Interaction{
+		Type:   objects.InteractionButton,
+		Member: &objects.GuildMember{User: &objects.User{ID: 1}},
+		Data:   []byte(`{"custom_id": "unknown", "component_type": 2}`),
+	})
+	assert.EqualError(t, err, "cannot find handler for component: unknown")
+}
@@ -0,0 +1,43 @@
+package interactions
+
+import (
+	"fmt"
+
+	"github.com/Postcord/objects"
+)
+
+// targetUser returns the user a user command was used on. The member is only
+// set when it was used in a guild.
+func targetUser(data *objects.ApplicationCommandInteractionData) (*objects.User, *objects.GuildMember, error) {
+	usr, ok := data.Resolved.Users[data.TargetID]
+	if !ok {
+		return nil, nil, fmt.Errorf("target user %d not resolved", data.TargetID)
+	}
+
+	var member *objects.GuildMember
+	if m, ok := data.Resolved.Members[data.TargetID]; ok {
+		m.User = &usr
+		member = &m
+	}
+
+	return &usr, member, nil
+}
+
+// targetMessage returns the message a message command was used on.
+func targetMessage(data *objects.ApplicationCommandInteractionData) (*objects.Message, error) {
+	msg, ok := data.Resolved.Messages[data.TargetID]
+	if !ok {
+		return nil, fmt.Errorf("target message %d not resolved", data.TargetID)
+	}
+
+	return &msg, nil
+}
+
+// displayName is what the user is called in the guild, falling back to their
+// username.
+func displayName(usr *objects.User, member *objects.GuildMember) string {
+	if member != nil && member.Nick != "" {
+		return member.Nick
+	}
+	return usr.Username
+}
@@ -0,0 +1,83 @@
+package interactions
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Postcord/objects"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestTargetUser(t *testing.T) {
+	data := parseCommandData(t, `{
+		"name": "Compare taste",
+		"type": 2,
+		"target_id": "42",
+		"resolved": {
+			"users": {"42": {"id": "42", "username": "noah"}},
+			"members": {"42": {"nick": "Noah"}}
+		}
+	}`)
+
+	usr, member, err := targetUser(data)
+	require.NoError(t, err)
+	assert.Equal(t, objects.Snowflake(42), usr.ID)
+	require.NotNil(t, member)
+	assert.Equal(t, usr, member.User)
+	assert.Equal(t, "Noah", displayName(usr, member))
+
+	_, err = targetMessage(data)
+	assert.EqualError(t, err, "target message 42 not resolved")
+}
+
+func TestTasteOverlap(t *testing.T) {
+	shared, score := tasteOverlap(
+		[]string{"Radiohead", "Björk", "Low", "Slowdive"},
+		[]string{"Slowdive", "Low", "Portishead"},
+	)
+	assert.Equal(t, []string{"Low", "Slowdive"}, shared)
+	assert.Equal(t, 40, score)
+
+	_, score = tasteOverlap(nil, nil)
+	assert.Equal(t, 0, score)
+}
+
+func TestRoutesByCommandType(t *testing.T) {
+	rtr := NewRouter(zaptest.NewLogger(t), nil, nil)
+
+	respondWith := func(content string) handler {
+		return func(ctx context.Context, interaction *objects.Interaction, interactionData *objects.ApplicationCommandInteractionData) (*objects.InteractionResponse, error) {
+			return ephemeralResponse(content), nil
+		}
+	}
+	err := rtr.Register(
+		&Interaction{
+			ApplicationCommand: &objects.ApplicationCommand{Name: "compare"},
+			handler:            respondWith("slash"),
+		},
+		&Interaction{
+			ApplicationCommand: &objects.ApplicationCommand{
+				Name: "compare",
+				Type: commandType(objects.CommandTypeUser),
+			},
+			handler: respondWith("user"),
+		},
+	)
+	require.NoError(t, err)
+
+	member := &objects.GuildMember{User: &objects.User{ID: 1}}
+	for data, want := range map[string]string{
+		`{"name": "compare", "type": 1}`:                    "slash",
+		`{"name": "compare", "type": 2, "target_id": "42"}`: "user",
+	} {
+		res, err := rtr.handleCommand(context.Background(), &objects.Interaction{
+			Type:   objects.InteractionApplicationCommand,
+			Member: member,
+			Data:   []byte(data),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, want, res.(*objects.InteractionResponse).Data.Content)
+	}
+}
@@ -0,0 +1,174 @@
+package interactions
+
+import (
+	"context"
+	"time"
+
+	"oscen/tracer"
+
+	"github.com/Postcord/objects"
+	"github.com/Postcord/rest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// deferredTimeout is how long a deferred handler has to finish. Interaction
+// tokens are only valid for 15 minutes, after which we can't respond.
+const deferredTimeout = 14 * time.Minute
+
+// deferredResponse is the "thinking" placeholder sent for a deferred handler.
+// Whether a message is ephemeral can't be changed once it's sent, and we don't
+// know yet if the handler will respond with an ephemeral message, so the
+// placeholder is only shown to the user who invoked it.
+func deferredResponse() *objects.InteractionResponse {
+	return &objects.InteractionResponse{
+		Type: objects.ResponseDeferredChannelMessageWithSource,
+		Data: &objects.InteractionApplicationCommandCallbackData{
+			Flags: objects.ResponseFlagEphemeral,
+		},
+	}
+}
+
+// deferHandler wraps a slow handler so Discord gets an immediate "thinking"
+// response, and the handler's response replaces it once it's done.
+func (r *router) deferHandler(h handler) handler {
+	return func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		interactionData *objects.ApplicationCommandInteractionData,
+	) (*objects.InteractionResponse, error) {
+		r.runInBackground(ctx, interaction, func(ctx context.Context) (*objects.InteractionResponse, error) {
+			return h(ctx, interaction, interactionData)
+		})
+
+		return deferredResponse(), nil
+	}
+}
+
+// deferComponentHandler is deferHandler for components. Their response is
+// sent as a new message, the message the component is attached to is left as
+// is.
+func (r *router) deferComponentHandler(h componentHandler) componentHandler {
+	return func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		componentData *objects.ApplicationComponentInteractionData,
+		state []string,
+	) (*objects.InteractionResponse, error) {
+		r.runInBackground(ctx, interaction, func(ctx context.Context) (*objects.InteractionResponse, error) {
+			return h(ctx, interaction, componentData, state)
+		})
+
+		return deferredResponse(), nil
+	}
+}
+
+// runInBackground runs fn once the request has been responded to, using its
+// response in place of the placeholder. A nil response leaves it untouched.
+func (r *router) runInBackground(
+	ctx context.Context,
+	interaction *objects.Interaction,
+	fn func(ctx context.Context) (*objects.InteractionResponse, error),
+) {
+	// The request context is cancelled as soon as we respond, so detach from
+	// it but stay in the same trace.
+	ctx = trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+
+	r.background.Add(1)
+	go func() {
+		defer r.background.Done()
+
+		ctx, cancel := context.WithTimeout(ctx, deferredTimeout)
+		defer cancel()
+		ctx, childSpan := tracer.Start(ctx, "interactions.run_deferred")
+		defer childSpan.End()
+
+		var res *objects.InteractionResponse
+		_, err := recovered(func() (interface{}, error) {
+			var err error
+			res, err = fn(ctx)
+			return nil, err
+		})
+		if err != nil {
+			res = r.failed(ctx, err)
+		}
+
+		if res == nil || res.Data == nil {
+			return
+		}
+
+		if err := replacePlaceholder(r.rest, interaction, res.Data); err != nil {
+			childSpan.RecordError(err)
+			r.log.Error("failed to send deferred response", zap.Error(err))
+		}
+	}()
+}
+
+// Wait blocks until all deferred handlers have finished.
+func (r *router) Wait() {
+	r.background.Wait()
+}
+
+// replacePlaceholder responds with data in place of the ephemeral placeholder
+// sent by deferredResponse.
+func replacePlaceholder(
+	dc discordClient,
+	interaction *objects.Interaction,
+	data *objects.InteractionApplicationCommandCallbackData,
+) error {
+	if data.Flags&objects.ResponseFlagEphemeral != 0 {
+		return editOriginalResponse(dc, interaction, data)
+	}
+
+	// Editing the placeholder would leave the response only visible to the
+	// invoker, so send it as a new message and get rid of the placeholder.
+	if err := sendFollowUp(dc, interaction, data); err != nil {
+		return err
+	}
+	return deleteOriginalResponse(dc, interaction)
+}
+
+// editOriginalResponse replaces the response to the interaction, e.g. the
+// placeholder sent for a deferred command.
+func editOriginalResponse(
+	dc discordClient,
+	interaction *objects.Interaction,
+	data *objects.InteractionApplicationCommandCallbackData,
+) error {
+	_, err := dc.EditOriginalInteractionResponse(interaction.ApplicationID, interaction.Token, &rest.EditWebhookMessageParams{
+		Content:         data.Content,
+		Embeds:          data.Embeds,
+		AllowedMentions: data.AllowedMentions,
+		Components:      data.Components,
+	})
+	return err
+}
+
+// deleteOriginalResponse deletes the response to the interaction. The client
+// can only delete messages by ID, which we only find out by editing it.
+func deleteOriginalResponse(dc discordClient, interaction *objects.Interaction) error {
+	msg, err := dc.EditOriginalInteractionResponse(interaction.ApplicationID, interaction.Token, &rest.EditWebhookMessageParams{
+		Content: "Done!",
+	})
+	if err != nil {
+		return err
+	}
+	return dc.DeleteWebhookMessage(msg.ID, interaction.ApplicationID, interaction.Token)
+}
+
+// sendFollowUp sends another message in response to the interaction.
+func sendFollowUp(
+	dc discordClient,
+	interaction *objects.Interaction,
+	data *objects.InteractionApplicationCommandCallbackData,
+) error {
+	_, err := dc.ExecuteWebhook(interaction.ApplicationID, interaction.Token, &rest.ExecuteWebhookParams{
+		Wait:            true,
+		Content:         data.Content,
+		TTS:             data.TTS,
+		Embeds:          data.Embeds,
+		AllowedMentions: data.AllowedMentions,
+		Components:      data.Components,
+	})
+	return err
+}
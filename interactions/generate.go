@@ -2,54 +2,102 @@ package interactions
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"oscen/playlistcreator"
 	"oscen/repositories/users"
 
-	spotifyauth "github.com/zmb3/spotify/v2/auth"
-
 	"github.com/Postcord/objects"
 )
 
-func NewGenerateInteraction(userRepo *users.PostgresRepository, auth *spotifyauth.Authenticator, playlistCreator *playlistcreator.PlaylistCreator) *Interaction {
+const regenerateComponent = "generate.regenerate"
+
+func generatedPlaylistMessage(url string) (*objects.InteractionApplicationCommandCallbackData, error) {
+	regenerateID, err := customID(regenerateComponent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &objects.InteractionApplicationCommandCallbackData{
+		Content: fmt.Sprintf("You can find your new playlist here: %s", url),
+		Components: []*objects.Component{
+			{
+				Type: objects.ComponentTypeActionRow,
+				Components: []*objects.Component{
+					{
+						Type:     objects.ComponentTypeButton,
+						Style:    objects.ButtonStyleSecondary,
+						Label:    "Regenerate",
+						CustomID: regenerateID,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func NewGenerateInteraction(userRepo users.Repository, auth spotifyAuth, playlistCreator *playlistcreator.PlaylistCreator) *Interaction {
 	h := func(
 		ctx context.Context,
 		interaction *objects.Interaction,
 		interactionData *objects.ApplicationCommandInteractionData,
 	) (*objects.InteractionResponse, error) {
-		client, err := ensureSpotifyClient(ctx, interaction, userRepo, auth)
+		url, err := playlistCreator.Create(ctx, interaction, spotifyClientFrom(ctx))
+		if errors.Is(err, playlistcreator.ErrMembersNotSynced) {
+			return ephemeralResponse(membersNotSynced), nil
+		}
 		if err != nil {
-			if err == users.ErrUserNotRegistered {
-				return &objects.InteractionResponse{
-					Type: objects.ResponseChannelMessageWithSource,
-					Data: &objects.InteractionApplicationCommandCallbackData{
-						Content: "You need to use /register before you can use other commands",
-					},
-				}, nil
-			}
-
 			return nil, err
 		}
 
-		url, err := playlistCreator.Create(ctx, interaction, client)
+		data, err := generatedPlaylistMessage(*url)
 		if err != nil {
 			return nil, err
 		}
 
 		return &objects.InteractionResponse{
 			Type: objects.ResponseChannelMessageWithSource,
-			Data: &objects.InteractionApplicationCommandCallbackData{
-				Content: fmt.Sprintf("You can find your new playlist here: %s", *url),
-			},
+			Data: data,
 		}, nil
 	}
 
+	linked := requireSpotifyLink(userRepo, auth)
+
+	// Regenerating creates a new playlist for whoever pressed the button, so
+	// it's sent as a new message rather than replacing the original.
+	regenerate := func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		componentData *objects.ApplicationComponentInteractionData,
+		state []string,
+	) (*objects.InteractionResponse, error) {
+		res, err := linked(h)(ctx, interaction, nil)
+		if err != nil || res.Data.Flags&objects.ResponseFlagEphemeral != 0 {
+			return res, err
+		}
+
+		// Nothing says who pressed the button, so make it clear who it's for.
+		res.Data.Content = fmt.Sprintf("<@%d> %s", invokerOf(interaction).ID, res.Data.Content)
+		res.Data.AllowedMentions = noMentions
+		return res, nil
+	}
+
 	return &Interaction{
 		ApplicationCommand: &objects.ApplicationCommand{
 			Name:              "generate",
 			Description:       "Generates a playlist for your current guild",
 			DefaultPermission: true,
 		},
-		handler: h,
+		handler:    h,
+		middleware: []Middleware{linked},
+		// Looking up every member's listens takes a while in big guilds.
+		deferred: true,
+		components: []*Component{
+			{
+				Name:     regenerateComponent,
+				handler:  regenerate,
+				deferred: true,
+			},
+		},
 	}
 }
@@ -0,0 +1,159 @@
+package interactions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oscen/playlistcreator"
+	"oscen/repositories/users"
+	"strconv"
+	"strings"
+
+	"github.com/Postcord/objects"
+	"github.com/zmb3/spotify/v2"
+)
+
+const customPlaylistModal = "generate.custom"
+
+var playlistTimeRanges = map[string]spotify.Range{
+	"short":  spotify.ShortTermRange,
+	"medium": spotify.MediumTermRange,
+	"long":   spotify.LongTermRange,
+}
+
+// parsePlaylistOptions validates what was entered in the custom playlist
+// modal. Errors are shown to the user.
+func parsePlaylistOptions(values map[string]string) (playlistcreator.Options, error) {
+	opts := playlistcreator.Options{
+		Name: strings.TrimSpace(values["name"]),
+	}
+
+	if size := strings.TrimSpace(values["size"]); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 1 || n > playlistcreator.MaxSize {
+			return opts, optionErrorf("The size must be a number between 1 and %d", playlistcreator.MaxSize)
+		}
+		opts.Size = n
+	}
+
+	if tr := strings.ToLower(strings.TrimSpace(values["time_range"])); tr != "" {
+		r, ok := playlistTimeRanges[tr]
+		if !ok {
+			return opts, optionErrorf("The time range must be short, medium or long")
+		}
+		opts.TimeRange = r
+	}
+
+	switch strings.ToLower(strings.TrimSpace(values["visibility"])) {
+	case "", "public":
+	case "private":
+		opts.Private = true
+	default:
+		return opts, optionErrorf("The visibility must be public or private")
+	}
+
+	return opts, nil
+}
+
+func NewCustomGenerateInteraction(userRepo users.Repository, auth spotifyAuth, playlistCreator *playlistcreator.PlaylistCreator) *Interaction {
+	open := func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		interactionData *objects.ApplicationCommandInteractionData,
+	) (*modal, error) {
+		id, err := customID(customPlaylistModal)
+		if err != nil {
+			return nil, err
+		}
+
+		return newModal(
+			id,
+			"Generate a playlist",
+			textInput{
+				CustomID:    "name",
+				Style:       textInputShort,
+				Label:       "Name",
+				MaxLength:   100,
+				Placeholder: "Guild Playlist",
+			},
+			textInput{
+				CustomID:    "size",
+				Style:       textInputShort,
+				Label:       "Number of tracks",
+				MaxLength:   2,
+				Placeholder: "5 per member",
+			},
+			textInput{
+				CustomID:    "time_range",
+				Style:       textInputShort,
+				Label:       "Time range of top tracks",
+				MaxLength:   6,
+				Placeholder: "short, medium or long",
+			},
+			textInput{
+				CustomID:    "visibility",
+				Style:       textInputShort,
+				Label:       "Visibility",
+				MaxLength:   7,
+				Placeholder: "public or private",
+			},
+		), nil
+	}
+
+	linked := requireSpotifyLink(userRepo, auth)
+
+	submit := func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		values map[string]string,
+		state []string,
+	) (*objects.InteractionResponse, error) {
+		opts, err := parsePlaylistOptions(values)
+		if err != nil {
+			return ephemeralResponse(err.Error()), nil
+		}
+
+		// Modal submits aren't command handlers, so wrap the part needing
+		// the link in one.
+		return linked(func(
+			ctx context.Context,
+			interaction *objects.Interaction,
+			_ *objects.ApplicationCommandInteractionData,
+		) (*objects.InteractionResponse, error) {
+			url, err := playlistCreator.CreateWithOptions(ctx, interaction, spotifyClientFrom(ctx), opts)
+			if errors.Is(err, playlistcreator.ErrPrivateNotAllowed) {
+				return ephemeralResponse("Oscen can't make private playlists for you until you use /register again"), nil
+			}
+			if errors.Is(err, playlistcreator.ErrMembersNotSynced) {
+				return ephemeralResponse(membersNotSynced), nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			return &objects.InteractionResponse{
+				Type: objects.ResponseChannelMessageWithSource,
+				Data: &objects.InteractionApplicationCommandCallbackData{
+					Content: fmt.Sprintf("You can find your new playlist here: %s", *url),
+				},
+			}, nil
+		})(ctx, interaction, nil)
+	}
+
+	return &Interaction{
+		ApplicationCommand: &objects.ApplicationCommand{
+			Name:              "generate-custom",
+			Description:       "Generates a playlist for your current guild, with your choice of settings",
+			DefaultPermission: true,
+		},
+		modal: open,
+		modalSubmits: []*ModalSubmit{
+			{
+				Name:    customPlaylistModal,
+				handler: submit,
+				// Same as /generate, this takes a while in big guilds.
+				deferred: true,
+			},
+		},
+	}
+}
@@ -0,0 +1,117 @@
+package interactions
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"oscen/historyimport"
+	"oscen/repositories/listens"
+	"strings"
+	"time"
+
+	"github.com/Postcord/objects"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Discord's own upload limit for boosted servers.
+const maxHistoryFileSize = 100 * 1024 * 1024
+
+// NewImportHistoryInteraction is a message command that imports the Spotify
+// extended streaming history files attached to a message.
+func NewImportHistoryInteraction(listensRepo listens.Repository) *Interaction {
+	httpClient := &http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+		Timeout:   30 * time.Second,
+	}
+
+	h := func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		interactionData *objects.ApplicationCommandInteractionData,
+	) (*objects.InteractionResponse, error) {
+		inv := invokerOf(interaction)
+		msg, err := targetMessage(interactionData)
+		if err != nil {
+			return nil, err
+		}
+
+		// Otherwise anyone could fill their history with someone else's.
+		if msg.Author == nil || msg.Author.ID != inv.ID {
+			return ephemeralResponse("You can only import history files you uploaded yourself"), nil
+		}
+
+		importer := historyimport.New()
+		files := 0
+		for _, attachment := range msg.Attachments {
+			if !strings.HasSuffix(strings.ToLower(attachment.Filename), ".json") {
+				continue
+			}
+			if attachment.Size > maxHistoryFileSize {
+				return ephemeralResponse(fmt.Sprintf("%s is too large to import", attachment.Filename)), nil
+			}
+
+			body, err := downloadAttachment(ctx, httpClient, attachment)
+			if err != nil {
+				return nil, err
+			}
+			err = importer.Add(body)
+			_ = body.Close()
+			if err != nil {
+				return ephemeralResponse(fmt.Sprintf(
+					"%s doesn't look like a Spotify extended streaming history file", attachment.Filename,
+				)), nil
+			}
+			files++
+		}
+
+		if files == 0 {
+			return ephemeralResponse("That message doesn't have any Spotify streaming history files attached"), nil
+		}
+
+		inserted, err := listensRepo.ImportListens(ctx, inv.DiscordID(), importer.Entries())
+		if err != nil {
+			return nil, err
+		}
+
+		stats := importer.Stats
+		msgFmt := "Imported %d new listens from %d file(s). %d entries were skipped as they weren't tracks or were played for under %s."
+		return ephemeralResponse(fmt.Sprintf(
+			msgFmt,
+			inserted,
+			files,
+			stats.NotTrack+stats.MissingTrack+stats.TooShort,
+			historyimport.DefaultMinPlayed,
+		)), nil
+	}
+
+	return &Interaction{
+		ApplicationCommand: &objects.ApplicationCommand{
+			Name:              "Import Spotify history",
+			Type:              commandType(objects.CommandTypeMessage),
+			DefaultPermission: true,
+		},
+		handler:   h,
+		dmCapable: true,
+		// Downloading and importing years of history can take a while.
+		deferred: true,
+	}
+}
+
+func downloadAttachment(ctx context.Context, client *http.Client, attachment *objects.Attachment) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, attachment.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status downloading %s: %s", attachment.Filename, resp.Status)
+	}
+
+	return resp.Body, nil
+}
@@ -8,7 +8,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"oscen/repositories/seen"
 	"oscen/tracer"
+	"strconv"
+	"sync"
+	"time"
 
 	"go.opentelemetry.io/otel/attribute"
 
@@ -28,77 +32,199 @@ type handler = func(
 type Interaction struct {
 	*objects.ApplicationCommand
 	handler handler
+	// subcommands holds the handlers for commands with subcommands, keyed by
+	// the path below the command name, e.g. "top-artists" or "group sub".
+	subcommands map[string]handler
+	// deferred marks commands that may take longer than Discord's three
+	// second deadline. They are acknowledged straight away and the response
+	// is filled in once the handler returns.
+	deferred bool
+	// components handle the buttons and select menus sent in this command's
+	// responses.
+	components []*Component
+	// autocomplete suggests values for options, keyed by the path to the
+	// option below the command name, e.g. "artist name".
+	autocomplete map[string]autocompleteHandler
+	// modal is used instead of handler for commands that open a modal.
+	modal modalOpener
+	// modalSubmits handle the modals opened by this command.
+	modalSubmits []*ModalSubmit
+	// dmCapable commands can be used in DMs with Oscen, rather than only in
+	// guilds.
+	dmCapable bool
+	// middleware wraps this command's handlers, inside any deferral.
+	middleware []Middleware
 }
 
+// ephemeralResponse replies with a message only the invoking user can see.
+func ephemeralResponse(content string) *objects.InteractionResponse {
+	return &objects.InteractionResponse{
+		Type: objects.ResponseChannelMessageWithSource,
+		Data: &objects.InteractionApplicationCommandCallbackData{
+			Content: content,
+			Flags:   objects.ResponseFlagEphemeral,
+		},
+	}
+}
+
+// noMentions stops a response pinging the users it names.
+var noMentions = &objects.AllowedMentions{Parse: []string{}}
+
+// membersNotSynced is the reply to commands about a guild's members before
+// Oscen has found out who they are.
+const membersNotSynced = "Oscen hasn't found out who's in this server yet, try again in a few minutes"
+
 type router struct {
-	routes       map[string]handler
-	interactions []*Interaction
-	rest         *rest.Client
-	log          *zap.Logger
-	publicKey    ed25519.PublicKey
+	routes   map[string]handler
+	commands map[string]*Interaction
+	// componentRoutes are keyed by the component name in the custom_id.
+	componentRoutes map[string]componentHandler
+	// autocompleteRoutes are keyed by the full path to the option.
+	autocompleteRoutes map[string]autocompleteHandler
+	autocompleteCache  autocompleteCache
+	autocompleteBudget time.Duration
+	modalRoutes        map[string]modalOpener
+	modalSubmitRoutes  map[string]modalSubmitHandler
+	middleware         []Middleware
+	interactions       []*Interaction
+	rest               discordClient
+	log                *zap.Logger
+	publicKey          ed25519.PublicKey
+	// seen stops replayed requests being handled twice.
+	seen SeenInteractions
+	now  func() time.Time
+	// background tracks deferred handlers that are still running.
+	background sync.WaitGroup
 }
 
-func NewRouter(log *zap.Logger, publicKey ed25519.PublicKey, rest *rest.Client) *router {
+// discordClient is the part of *rest.Client used by interactions.
+type discordClient interface {
+	EditOriginalInteractionResponse(applicationID objects.Snowflake, token string, params *rest.EditWebhookMessageParams) (*objects.Message, error)
+	ExecuteWebhook(id objects.Snowflake, token string, params *rest.ExecuteWebhookParams) (*objects.Message, error)
+	DeleteWebhookMessage(messageID, webhookID objects.Snowflake, token string) error
+}
+
+func NewRouter(log *zap.Logger, publicKey ed25519.PublicKey, rest discordClient) *router {
 	return &router{
-		rest:         rest,
-		routes:       map[string]handler{},
-		interactions: []*Interaction{},
-		log:          log,
-		publicKey:    publicKey,
+		rest:               rest,
+		routes:             map[string]handler{},
+		commands:           map[string]*Interaction{},
+		componentRoutes:    map[string]componentHandler{},
+		autocompleteRoutes: map[string]autocompleteHandler{},
+		autocompleteBudget: defaultAutocompleteBudget,
+		modalRoutes:        map[string]modalOpener{},
+		modalSubmitRoutes:  map[string]modalSubmitHandler{},
+		interactions:       []*Interaction{},
+		log:                log,
+		publicKey:          publicKey,
+		seen:               seen.NewMemoryRepository(),
+		now:                time.Now,
 	}
 }
 
+// maxTimestampSkew is how far a request's signature timestamp may be from our
+// clock before we treat it as a replay.
+const maxTimestampSkew = 5 * time.Minute
+
+// SeenInteractions remembers which interactions have been handled.
+type SeenInteractions interface {
+	// MarkSeen returns false if the interaction was already seen and that
+	// hasn't expired.
+	MarkSeen(ctx context.Context, interactionID string, until time.Time) (bool, error)
+}
+
+// SetSeenInteractions replaces the default in memory store, which only works
+// when a single replica handles interactions.
+func (r *router) SetSeenInteractions(s SeenInteractions) {
+	r.seen = s
+}
+
 func (r *router) Register(interactions ...*Interaction) error {
 	for _, i := range interactions {
 		r.log.Info("registering command with router", zap.String("name", i.Name))
 
-		r.routes[i.Name] = i.handler
-		r.interactions = append(r.interactions, i)
-	}
-
-	return nil
-}
+		key := routeKey(commandTypeOf(i.ApplicationCommand), i.Name)
 
-func (r *router) SyncInteractions(guildId *objects.Snowflake) error {
-	usr, err := r.rest.GetCurrentUser()
-	if err != nil {
-		return err
-	}
+		wrap := func(h handler) handler { return chain(h, i.middleware...) }
+		if i.deferred {
+			wrap = func(h handler) handler { return r.deferHandler(chain(h, i.middleware...)) }
+		}
 
-	for _, i := range r.interactions {
-		if guildId != nil {
-			_, err = r.rest.AddGuildCommand(usr.ID, *guildId, i.ApplicationCommand)
-			if err != nil {
-				return err
+		if i.handler != nil {
+			r.routes[key] = wrap(i.handler)
+		}
+		for path, h := range i.subcommands {
+			r.routes[key+" "+path] = wrap(h)
+		}
+		for _, c := range i.components {
+			h := c.handler
+			if c.deferred {
+				h = r.deferComponentHandler(h)
+			}
+			// Checked first, so other users get told straight away.
+			if c.owned {
+				h = ownedComponentHandler(h)
 			}
-		} else {
-			_, err = r.rest.AddCommand(usr.ID, i.ApplicationCommand)
-			if err != nil {
-				return err
+			r.componentRoutes[c.Name] = h
+		}
+		if i.modal != nil {
+			r.modalRoutes[key] = i.modal
+		}
+		for _, m := range i.modalSubmits {
+			h := m.handler
+			if m.deferred {
+				h = r.deferModalSubmitHandler(h)
 			}
+			r.modalSubmitRoutes[m.Name] = h
 		}
+		for path, h := range i.autocomplete {
+			r.autocompleteRoutes[key+" "+path] = h
+		}
+		r.commands[key] = i
+		r.interactions = append(r.interactions, i)
 	}
 
 	return nil
 }
 
-func (r *router) verifySignature(req *http.Request, body []byte) error {
+func (r *router) SyncInteractions(ctx context.Context, commands *CommandsClient, guildId *objects.Snowflake) error {
+	_, err := SyncCommands(ctx, r.log, commands, guildId, r.interactions, false)
+	return err
+}
+
+// verifySignature checks the request came from Discord, returning when it
+// was signed.
+func (r *router) verifySignature(req *http.Request, body []byte) (time.Time, error) {
 	signatureHeader := req.Header.Get("X-Signature-ED25519")
-	timestamp := []byte(req.Header.Get("X-Signature-Timestamp"))
+	timestamp := req.Header.Get("X-Signature-Timestamp")
 
 	signature, err := hex.DecodeString(signatureHeader)
 	if err != nil {
-		return fmt.Errorf("could not decode signature: %w", err)
+		return time.Time{}, fmt.Errorf("could not decode signature: %w", err)
 	}
 
-	if !ed25519.Verify(r.publicKey, append(timestamp, body...), signature) {
-		return fmt.Errorf("invalid signature")
+	if !ed25519.Verify(r.publicKey, append([]byte(timestamp), body...), signature) {
+		return time.Time{}, fmt.Errorf("invalid signature")
 	}
 
-	return nil
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse signature timestamp: %w", err)
+	}
+	signedAt := time.Unix(secs, 0)
+
+	skew := r.now().Sub(signedAt)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxTimestampSkew {
+		return time.Time{}, fmt.Errorf("signature timestamp is %s from now", skew)
+	}
+
+	return signedAt, nil
 }
 
-func (r *router) handleCommand(ctx context.Context, interaction *objects.Interaction) (*objects.InteractionResponse, error) {
+func (r *router) handleCommand(ctx context.Context, interaction *objects.Interaction) (interface{}, error) {
 	r.log.Debug("interaction.handle_command", zap.Any("data", interaction))
 	ctx, childSpan := tracer.Start(ctx, "interactions.handle_command")
 	defer childSpan.End()
@@ -108,20 +234,79 @@ func (r *router) handleCommand(ctx context.Context, interaction *objects.Interac
 	if err != nil {
 		return nil, err
 	}
+	// Postcord doesn't parse the command type.
+	typeData := struct {
+		Type objects.ApplicationCommandType `json:"type"`
+	}{}
+	err = json.Unmarshal(interaction.Data, &typeData)
+	if err != nil {
+		return nil, err
+	}
 
 	childSpan.SetAttributes(
 		attribute.String("io.oscen.command_name", commandData.Name),
-		attribute.String("io.oscen.discord_user", fmt.Sprintf("%d", interaction.Member.User.ID)),
+		attribute.String("io.oscen.discord_user", invokerOf(interaction).DiscordID()),
 	)
 
-	handler, ok := r.routes[commandData.Name]
-	if !ok {
+	var defs []objects.ApplicationCommandOption
+	cmd, ok := r.commands[routeKey(typeData.Type, commandData.Name)]
+	if ok {
+		defs = cmd.Options
+	}
+	path, opts, defs := resolveCommandPath(commandData, defs)
+	childSpan.SetAttributes(attribute.String("io.oscen.command_path", path))
+
+	key := routeKey(typeData.Type, path)
+	handler, ok := r.routes[key]
+	opener, opensModal := r.modalRoutes[key]
+	if !ok && !opensModal {
 		return nil, fmt.Errorf(
-			"cannot find handler for interaction: %s", commandData.Name,
+			"cannot find handler for interaction: %s", path,
 		)
 	}
 
-	return handler(ctx, interaction, commandData)
+	// Discord hides guild only commands in DMs, but they can still be used
+	// until the command list is synced.
+	if !cmd.dmCapable && !invokerOf(interaction).InGuild() {
+		return ephemeralResponse("This command can only be used in a server"), nil
+	}
+
+	if err := validateOptions(defs, opts); err != nil {
+		r.log.Info("invalid command options", zap.String("path", path), zap.Error(err))
+		return ephemeralResponse(err.Error()), nil
+	}
+
+	if opensModal {
+		m, err := opener(ctx, interaction, commandData)
+		if err != nil {
+			return nil, err
+		}
+		return &modalResponse{Type: responseModal, Data: m}, nil
+	}
+
+	return chain(handler, r.middleware...)(ctx, interaction, commandData)
+}
+
+// routeKey namespaces routes by command type, as Discord lets user and
+// message commands share names with slash commands.
+func routeKey(t objects.ApplicationCommandType, path string) string {
+	if t == 0 || t == objects.CommandTypeChatInput {
+		return path
+	}
+	return fmt.Sprintf("%d:%s", t, path)
+}
+
+func commandTypeOf(cmd *objects.ApplicationCommand) objects.ApplicationCommandType {
+	if cmd.Type == nil {
+		return objects.CommandTypeChatInput
+	}
+	return objects.ApplicationCommandType(*cmd.Type)
+}
+
+// commandType is for setting objects.ApplicationCommand.Type.
+func commandType(t objects.ApplicationCommandType) *int {
+	i := int(t)
+	return &i
 }
 
 type httpStatusErr struct {
@@ -142,7 +327,7 @@ func (r *router) handleRequest(req *http.Request) (interface{}, *httpStatusErr)
 		return nil, wrapErrorForHTTP(401, err)
 	}
 
-	err = r.verifySignature(req, body)
+	signedAt, err := r.verifySignature(req, body)
 	if err != nil {
 		return nil, wrapErrorForHTTP(401, err)
 	}
@@ -153,18 +338,44 @@ func (r *router) handleRequest(req *http.Request) (interface{}, *httpStatusErr)
 		return nil, wrapErrorForHTTP(401, err)
 	}
 
+	// A replay must be signed within the skew, so only needs remembering
+	// for as long as that.
+	first, err := r.seen.MarkSeen(req.Context(), fmt.Sprintf("%d", interaction.ID), signedAt.Add(maxTimestampSkew))
+	if err != nil {
+		// The signature and timestamp still protect us, so carry on rather
+		// than failing every interaction.
+		r.log.Warn("failed to check for replayed interaction", zap.Error(err))
+	} else if !first {
+		return nil, wrapErrorForHTTP(409, fmt.Errorf("interaction %d already handled", interaction.ID))
+	}
+
+	ctx := req.Context()
+	var handle func() (interface{}, error)
 	switch interaction.Type {
 	case objects.InteractionRequestPing:
 		return objects.InteractionResponse{Type: objects.ResponsePong}, nil
 	case objects.InteractionApplicationCommand:
-		response, err := r.handleCommand(req.Context(), interaction)
-		if err != nil {
-			return nil, wrapErrorForHTTP(500, err)
-		}
-		return response, nil
+		handle = func() (interface{}, error) { return r.handleCommand(ctx, interaction) }
+	case interactionAutocomplete:
+		handle = func() (interface{}, error) { return r.handleAutocomplete(ctx, interaction) }
+	case interactionModalSubmit:
+		handle = func() (interface{}, error) { return r.handleModalSubmit(ctx, interaction) }
+	case objects.InteractionButton:
+		handle = func() (interface{}, error) { return r.handleComponent(ctx, interaction) }
+	default:
+		return nil, wrapErrorForHTTP(404, fmt.Errorf("could not handle request"))
 	}
 
-	return nil, wrapErrorForHTTP(404, fmt.Errorf("could not handle request"))
+	response, err := recovered(handle)
+	if err != nil {
+		res := r.failed(ctx, err)
+		// There's nowhere to show a message while someone is typing.
+		if interaction.Type == interactionAutocomplete {
+			return newAutocompleteResponse(nil), nil
+		}
+		return res, nil
+	}
+	return response, nil
 }
 
 func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
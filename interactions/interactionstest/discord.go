@@ -0,0 +1,194 @@
+package interactionstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Postcord/objects"
+	"github.com/Postcord/rest"
+)
+
+// Discord fakes the parts of Discord's REST API used by Oscen, served from an
+// httptest server. It is used in place of *rest.Client, which can't be
+// pointed anywhere but discord.com.
+type Discord struct {
+	Server *httptest.Server
+
+	mu       sync.Mutex
+	guilds   map[objects.Snowflake]*objects.Guild
+	requests []Request
+	nextID   objects.Snowflake
+}
+
+// Request is a call made to the fake Discord API.
+type Request struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+func NewDiscord(t *testing.T) *Discord {
+	d := &Discord{
+		guilds: map[objects.Snowflake]*objects.Guild{},
+		nextID: 900,
+	}
+	d.Server = httptest.NewServer(http.HandlerFunc(d.serve))
+	t.Cleanup(d.Server.Close)
+	return d
+}
+
+// AddGuild adds a guild for GetGuild to return.
+func (d *Discord) AddGuild(guild *objects.Guild) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.guilds[guild.ID] = guild
+}
+
+// Requests returns the calls made to the API so far.
+func (d *Discord) Requests() []Request {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]Request{}, d.requests...)
+}
+
+func (d *Discord) serve(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	recorded := Request{Method: req.Method, Path: req.URL.RequestURI()}
+	if len(body) > 0 {
+		recorded.Body = body
+	}
+	d.requests = append(d.requests, recorded)
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case req.Method == http.MethodPatch && len(parts) == 5 && parts[0] == "webhooks" && parts[3] == "messages":
+		d.writeMessage(w, body)
+	case req.Method == http.MethodDelete && len(parts) == 5 && parts[0] == "webhooks" && parts[3] == "messages":
+		w.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodPost && len(parts) == 3 && parts[0] == "webhooks":
+		d.writeMessage(w, body)
+	case req.Method == http.MethodGet && len(parts) == 2 && parts[0] == "guilds":
+		guild, ok := d.guilds[parseSnowflake(parts[1])]
+		if !ok {
+			http.Error(w, `{"message": "Unknown Guild", "code": 10004}`, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, guild)
+	default:
+		http.Error(w, `{"message": "404: Not Found", "code": 0}`, http.StatusNotFound)
+	}
+}
+
+// writeMessage responds to a request creating or editing a message with the
+// message, as Discord does.
+func (d *Discord) writeMessage(w http.ResponseWriter, body []byte) {
+	msg := &objects.Message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.nextID++
+	msg.ID = d.nextID
+	writeJSON(w, msg)
+}
+
+func (d *Discord) EditOriginalInteractionResponse(
+	applicationID objects.Snowflake,
+	token string,
+	params *rest.EditWebhookMessageParams,
+) (*objects.Message, error) {
+	msg := &objects.Message{}
+	path := fmt.Sprintf("/webhooks/%d/%s/messages/@original", applicationID, token)
+	return msg, d.do(http.MethodPatch, path, params, msg)
+}
+
+func (d *Discord) ExecuteWebhook(
+	id objects.Snowflake,
+	token string,
+	params *rest.ExecuteWebhookParams,
+) (*objects.Message, error) {
+	msg := &objects.Message{}
+	path := fmt.Sprintf("/webhooks/%d/%s", id, token)
+	if params.Wait {
+		path += "?wait=true"
+	}
+	return msg, d.do(http.MethodPost, path, params, msg)
+}
+
+func (d *Discord) DeleteWebhookMessage(messageID, webhookID objects.Snowflake, token string) error {
+	path := fmt.Sprintf("/webhooks/%d/%s/messages/%d", webhookID, token, messageID)
+	return d.do(http.MethodDelete, path, nil, nil)
+}
+
+func (d *Discord) GetGuild(id objects.Snowflake) (*objects.Guild, error) {
+	guild := &objects.Guild{}
+	return guild, d.do(http.MethodGet, fmt.Sprintf("/guilds/%d", id), nil, guild)
+}
+
+func (d *Discord) do(method, path string, params interface{}, out interface{}) error {
+	var body []byte
+	if params != nil {
+		var err error
+		body, err = json.Marshal(params)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, d.Server.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := d.Server.Client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	// Matches the errors returned by *rest.Client.
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return &rest.ErrorREST{
+			Message: fmt.Sprintf("expected 200, got %d: %s", res.StatusCode, resBody),
+			Status:  res.StatusCode,
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(resBody, out)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func parseSnowflake(s string) objects.Snowflake {
+	id, _ := strconv.ParseUint(s, 10, 64)
+	return objects.Snowflake(id)
+}
@@ -0,0 +1,39 @@
+package interactionstest
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current output")
+
+// AssertGolden compares got, as indented JSON, with the test's golden file in
+// testdata. Run the tests with -update to write the golden files.
+func AssertGolden(t *testing.T, got interface{}) {
+	t.Helper()
+
+	actual, err := json.MarshalIndent(got, "", "  ")
+	require.NoError(t, err)
+	actual = append(actual, '\n')
+
+	name := strings.ReplaceAll(t.Name(), "/", "__")
+	path := filepath.Join("testdata", name+".golden")
+
+	if *update {
+		require.NoError(t, os.MkdirAll("testdata", 0o755))
+		require.NoError(t, ioutil.WriteFile(path, actual, 0o644))
+		return
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "run the tests with -update to create the golden file")
+	assert.Equal(t, string(expected), string(actual))
+}
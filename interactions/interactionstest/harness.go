@@ -0,0 +1,203 @@
+// Package interactionstest runs interactions end to end in tests. It sends
+// signed requests to a router the way Discord does, with fakes for the
+// Discord and Spotify APIs the commands call.
+package interactionstest
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"oscen/interactions"
+	"oscen/playlistcreator"
+	"oscen/repositories/catalog"
+	"oscen/repositories/guildmembers"
+	"oscen/repositories/listens"
+	"oscen/repositories/users"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Postcord/objects"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// ApplicationID is the application interactions are sent to.
+const ApplicationID objects.Snowflake = 1
+
+// Router is what the harness needs from the interactions router.
+type Router interface {
+	http.Handler
+	Register(interactions ...*interactions.Interaction) error
+	Use(mw ...interactions.Middleware)
+	// Wait blocks until deferred handlers have finished.
+	Wait()
+}
+
+type Harness struct {
+	t      *testing.T
+	key    ed25519.PrivateKey
+	Router Router
+
+	Discord *Discord
+	Spotify *Spotify
+	Users   *users.MemoryRepository
+	Listens *listens.MemoryRepository
+	Members *guildmembers.MemoryRepository
+	Catalog *catalog.MemoryRepository
+
+	// Guild is where interactions are sent from, and User who sends them.
+	Guild *objects.Guild
+	User  *objects.User
+
+	nextID objects.Snowflake
+}
+
+func New(t *testing.T) *Harness {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	h := &Harness{
+		t:       t,
+		key:     priv,
+		Discord: NewDiscord(t),
+		Spotify: NewSpotify(t),
+		Users:   users.NewMemoryRepository(),
+		Listens: listens.NewMemoryRepository(),
+		Members: guildmembers.NewMemoryRepository(),
+		Guild:   &objects.Guild{ID: 100, Name: "Test Guild"},
+		User:    &objects.User{ID: 200, Username: "tester", Discriminator: "0001"},
+		nextID:  1000,
+	}
+	h.Catalog = catalog.NewMemoryRepository(h.Listens)
+	h.Router = interactions.NewRouter(zaptest.NewLogger(t), pub, h.Discord)
+	h.Discord.AddGuild(h.Guild)
+	h.AddMember(h.User)
+
+	return h
+}
+
+// AddMember adds the user to Guild.
+func (h *Harness) AddMember(user *objects.User) {
+	err := h.Members.AddMembers(
+		context.Background(),
+		fmt.Sprintf("%d", h.Guild.ID),
+		[]string{fmt.Sprintf("%d", user.ID)},
+		time.Now(),
+	)
+	require.NoError(h.t, err)
+}
+
+// Dependencies are the fakes for building commands with.
+func (h *Harness) Dependencies() interactions.Dependencies {
+	return interactions.Dependencies{
+		UsersRepo:   h.Users,
+		ListensRepo: h.Listens,
+		MembersRepo: h.Members,
+		CatalogRepo: h.Catalog,
+		Auth:        h.Spotify.Authenticator(),
+		PlaylistCreator: playlistcreator.New(
+			h.Spotify.Authenticator(),
+			h.Discord,
+			h.Users,
+			h.Members,
+			zaptest.NewLogger(h.t),
+		),
+	}
+}
+
+// LinkSpotify registers the Discord user with Oscen, linked to a new Spotify
+// account.
+func (h *Harness) LinkSpotify(discordUser *objects.User, spotifyUser *SpotifyUser) {
+	token := h.Spotify.AddUser(spotifyUser)
+	err := h.Users.UpsertUser(context.Background(), users.UpsertUser{
+		DiscordID:    fmt.Sprintf("%d", discordUser.ID),
+		SpotifyToken: token,
+	})
+	require.NoError(h.t, err)
+}
+
+// Register adds interactions to the router.
+func (h *Harness) Register(i ...*interactions.Interaction) {
+	require.NoError(h.t, h.Router.Register(i...))
+}
+
+// Interaction builds an interaction sent by User in Guild. data is the
+// interaction's data, e.g. `{"name": "np", "type": 1}` for a command.
+func (h *Harness) Interaction(typ objects.InteractionType, data string) *objects.Interaction {
+	h.nextID++
+	return &objects.Interaction{
+		ID:            h.nextID,
+		ApplicationID: ApplicationID,
+		Type:          typ,
+		Data:          json.RawMessage(data),
+		GuildID:       h.Guild.ID,
+		ChannelID:     300,
+		Member:        &objects.GuildMember{User: h.User},
+		Token:         fmt.Sprintf("token-%d", h.nextID),
+		Version:       1,
+	}
+}
+
+// Command builds a slash command interaction.
+func (h *Harness) Command(data string) *objects.Interaction {
+	return h.Interaction(objects.InteractionApplicationCommand, data)
+}
+
+// InDM moves an interaction to User's DMs with Oscen.
+func InDM(interaction *objects.Interaction) *objects.Interaction {
+	interaction.GuildID = 0
+	interaction.ChannelID = 400
+	interaction.User = interaction.Member.User
+	interaction.Member = nil
+	return interaction
+}
+
+// SignedRequest builds the request Discord would send for the interaction.
+func (h *Harness) SignedRequest(interaction *objects.Interaction) *http.Request {
+	body, err := json.Marshal(interaction)
+	require.NoError(h.t, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := ed25519.Sign(h.key, append([]byte(timestamp), body...))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/discord/interactions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(sig))
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	return req
+}
+
+// Response is everything Discord sees from handling an interaction.
+type Response struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	// Discord holds the calls made to Discord's API while handling the
+	// interaction, including those made by deferred handlers.
+	Discord []Request `json:"discord,omitempty"`
+}
+
+// Send sends the interaction to the router, waiting for any deferred work to
+// finish.
+func (h *Harness) Send(interaction *objects.Interaction) *Response {
+	before := len(h.Discord.Requests())
+
+	rec := httptest.NewRecorder()
+	h.Router.ServeHTTP(rec, h.SignedRequest(interaction))
+	h.Router.Wait()
+
+	res := &Response{
+		Status:  rec.Code,
+		Discord: h.Discord.Requests()[before:],
+	}
+	if rec.Body.Len() > 0 {
+		res.Body = rec.Body.Bytes()
+	}
+	return res
+}
@@ -0,0 +1,230 @@
+package interactionstest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+)
+
+// Spotify fakes the parts of Spotify's Web API used by Oscen, served from an
+// httptest server. Users are told apart by their access token.
+type Spotify struct {
+	Server *httptest.Server
+
+	mu        sync.Mutex
+	users     map[string]*SpotifyUser
+	playlists []*Playlist
+}
+
+// SpotifyUser is what the fake knows about a Spotify account.
+type SpotifyUser struct {
+	ID string
+	// NowPlaying is nil when the user isn't listening to anything.
+	NowPlaying *spotify.CurrentlyPlaying
+	TopTracks  []spotify.FullTrack
+	TopArtists []spotify.FullArtist
+	// PublicPlaylistsOnly is set for accounts linked before Oscen asked to
+	// modify private playlists.
+	PublicPlaylistsOnly bool
+}
+
+// Playlist is a playlist created through the fake.
+type Playlist struct {
+	ID          string
+	Owner       string
+	Name        string
+	Description string
+	Public      bool
+	Tracks      []string
+}
+
+func NewSpotify(t *testing.T) *Spotify {
+	s := &Spotify{users: map[string]*SpotifyUser{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Server.Close)
+	return s
+}
+
+// AddUser adds an account, returning the token to store for it.
+func (s *Spotify) AddUser(usr *SpotifyUser) *oauth2.Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := &oauth2.Token{
+		AccessToken:  "access-" + usr.ID,
+		RefreshToken: "refresh-" + usr.ID,
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(time.Hour),
+	}
+	s.users[token.AccessToken] = usr
+	return token
+}
+
+// Playlists returns the playlists created so far.
+func (s *Spotify) Playlists() []*Playlist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*Playlist{}, s.playlists...)
+}
+
+func (s *Spotify) serve(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usr, ok := s.users[strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		writeSpotifyError(w, http.StatusUnauthorized, "Invalid access token")
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v1/")
+	parts := strings.Split(path, "/")
+	switch {
+	case req.Method == http.MethodGet && path == "me":
+		writeJSON(w, spotify.PrivateUser{User: spotify.User{ID: usr.ID, DisplayName: usr.ID}})
+	case req.Method == http.MethodGet && path == "me/player/currently-playing":
+		if usr.NowPlaying == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, usr.NowPlaying)
+	case req.Method == http.MethodGet && path == "me/top/tracks":
+		tracks := usr.TopTracks[:limit(req, len(usr.TopTracks))]
+		writeJSON(w, spotify.FullTrackPage{Tracks: tracks})
+	case req.Method == http.MethodGet && path == "me/top/artists":
+		artists := usr.TopArtists[:limit(req, len(usr.TopArtists))]
+		writeJSON(w, spotify.FullArtistPage{Artists: artists})
+	case req.Method == http.MethodPost && len(parts) == 3 && parts[0] == "users" && parts[2] == "playlists":
+		s.createPlaylist(w, req, usr, parts[1])
+	case req.Method == http.MethodPost && len(parts) == 3 && parts[0] == "playlists" && parts[2] == "tracks":
+		s.addTracks(w, req, parts[1])
+	default:
+		writeSpotifyError(w, http.StatusNotFound, "Service not found")
+	}
+}
+
+func (s *Spotify) createPlaylist(w http.ResponseWriter, req *http.Request, usr *SpotifyUser, owner string) {
+	body := struct {
+		Name        string `json:"name"`
+		Public      bool   `json:"public"`
+		Description string `json:"description"`
+	}{}
+	if err := decodeBody(req, &body); err != nil {
+		writeSpotifyError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !body.Public && usr.PublicPlaylistsOnly {
+		writeSpotifyError(w, http.StatusForbidden, "Insufficient client scope")
+		return
+	}
+
+	p := &Playlist{
+		ID:          fmt.Sprintf("playlist%d", len(s.playlists)+1),
+		Owner:       owner,
+		Name:        body.Name,
+		Description: body.Description,
+		Public:      body.Public,
+	}
+	s.playlists = append(s.playlists, p)
+
+	writeJSON(w, spotify.FullPlaylist{SimplePlaylist: spotify.SimplePlaylist{
+		ID:           spotify.ID(p.ID),
+		Name:         p.Name,
+		IsPublic:     p.Public,
+		ExternalURLs: map[string]string{"spotify": "https://open.spotify.com/playlist/" + p.ID},
+	}})
+}
+
+func (s *Spotify) addTracks(w http.ResponseWriter, req *http.Request, id string) {
+	body := struct {
+		URIs []string `json:"uris"`
+	}{}
+	if err := decodeBody(req, &body); err != nil {
+		writeSpotifyError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for _, p := range s.playlists {
+		if p.ID == id {
+			p.Tracks = append(p.Tracks, body.URIs...)
+			writeJSON(w, map[string]string{"snapshot_id": "snapshot"})
+			return
+		}
+	}
+	writeSpotifyError(w, http.StatusNotFound, "Not found.")
+}
+
+// Authenticator builds Spotify clients that talk to the fake, in place of
+// *spotifyauth.Authenticator.
+func (s *Spotify) Authenticator() *Authenticator {
+	return &Authenticator{spotify: s}
+}
+
+type Authenticator struct {
+	spotify *Spotify
+}
+
+func (a *Authenticator) AuthURL(state string, _ ...oauth2.AuthCodeOption) string {
+	return "https://accounts.spotify.com/authorize?state=" + url.QueryEscape(state)
+}
+
+func (a *Authenticator) Client(ctx context.Context, token *oauth2.Token) *http.Client {
+	target, _ := url.Parse(a.spotify.Server.URL)
+	return &http.Client{
+		Transport: &oauth2.Transport{
+			Source: oauth2.StaticTokenSource(token),
+			Base:   redirectTransport{target: target},
+		},
+	}
+}
+
+// redirectTransport sends every request to target, whatever host it was
+// for.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	req.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func limit(req *http.Request, max int) int {
+	n, err := strconv.Atoi(req.URL.Query().Get("limit"))
+	if err != nil || n > max {
+		return max
+	}
+	return n
+}
+
+func decodeBody(req *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func writeSpotifyError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"status": status, "message": message},
+	})
+}
@@ -0,0 +1,62 @@
+package interactionstest
+
+import (
+	"context"
+	"oscen/repositories/users"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+)
+
+type nopTokenStore struct{}
+
+func (nopTokenStore) UpdateToken(context.Context, string, *oauth2.Token) error {
+	return nil
+}
+
+func TestSpotify(t *testing.T) {
+	ctx := context.Background()
+	fake := NewSpotify(t)
+	token := fake.AddUser(&SpotifyUser{
+		ID: "alice",
+		TopArtists: []spotify.FullArtist{
+			{SimpleArtist: spotify.SimpleArtist{ID: "1", Name: "Boards of Canada"}},
+			{SimpleArtist: spotify.SimpleArtist{ID: "2", Name: "Aphex Twin"}},
+		},
+	})
+
+	usr := &users.User{DiscordID: "1", SpotifyToken: token}
+	client := usr.SpotifyClient(ctx, fake.Authenticator(), nopTokenStore{})
+
+	me, err := client.CurrentUser(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", me.ID)
+
+	np, err := client.PlayerCurrentlyPlaying(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, np.Item)
+
+	artists, err := client.CurrentUsersTopArtists(ctx, spotify.Limit(1))
+	require.NoError(t, err)
+	require.Len(t, artists.Artists, 1)
+	assert.Equal(t, "Boards of Canada", artists.Artists[0].Name)
+
+	playlist, err := client.CreatePlaylistForUser(ctx, "alice", "Mix", "For testing", false, false)
+	require.NoError(t, err)
+	_, err = client.AddTracksToPlaylist(ctx, playlist.ID, "a", "b")
+	require.NoError(t, err)
+	assert.Equal(t, []*Playlist{{
+		ID:          "playlist1",
+		Owner:       "alice",
+		Name:        "Mix",
+		Description: "For testing",
+		Tracks:      []string{"spotify:track:a", "spotify:track:b"},
+	}}, fake.Playlists())
+
+	stranger := &users.User{DiscordID: "2", SpotifyToken: &oauth2.Token{AccessToken: "unknown"}}
+	_, err = stranger.SpotifyClient(ctx, fake.Authenticator(), nopTokenStore{}).CurrentUser(ctx)
+	assert.Error(t, err)
+}
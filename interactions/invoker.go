@@ -0,0 +1,35 @@
+package interactions
+
+import (
+	"fmt"
+
+	"github.com/Postcord/objects"
+)
+
+// invoker is the user who triggered an interaction. Discord only sets Member
+// for interactions in guilds, and only User for ones in DMs.
+type invoker struct {
+	*objects.User
+	// Member is nil in DMs.
+	Member *objects.GuildMember
+}
+
+func invokerOf(i *objects.Interaction) invoker {
+	switch {
+	case i.Member != nil && i.Member.User != nil:
+		return invoker{User: i.Member.User, Member: i.Member}
+	case i.User != nil:
+		return invoker{User: i.User}
+	default:
+		return invoker{User: &objects.User{}}
+	}
+}
+
+// DiscordID is the ID we store the user under.
+func (inv invoker) DiscordID() string {
+	return fmt.Sprintf("%d", inv.ID)
+}
+
+func (inv invoker) InGuild() bool {
+	return inv.Member != nil
+}
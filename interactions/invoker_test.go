@@ -0,0 +1,64 @@
+package interactions
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Postcord/objects"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"golang.org/x/oauth2"
+)
+
+func TestInvokerOf(t *testing.T) {
+	member := &objects.GuildMember{User: &objects.User{ID: 1}}
+	inv := invokerOf(&objects.Interaction{Member: member})
+	assert.Equal(t, "1", inv.DiscordID())
+	assert.True(t, inv.InGuild())
+
+	inv = invokerOf(&objects.Interaction{User: &objects.User{ID: 2}})
+	assert.Equal(t, "2", inv.DiscordID())
+	assert.False(t, inv.InGuild())
+
+	// Shouldn't happen, but better than panicking.
+	inv = invokerOf(&objects.Interaction{})
+	assert.Equal(t, "0", inv.DiscordID())
+}
+
+type fakeAuthURLProvider struct{}
+
+func (fakeAuthURLProvider) AuthURL(state string, _ ...oauth2.AuthCodeOption) string {
+	return "https://example.com/auth?state=" + state
+}
+
+func TestDMCommands(t *testing.T) {
+	rtr := NewRouter(zaptest.NewLogger(t), nil, nil)
+	err := rtr.Register(
+		NewRegisterInteraction(fakeAuthURLProvider{}),
+		&Interaction{
+			ApplicationCommand: &objects.ApplicationCommand{Name: "guild-only"},
+			handler: func(ctx context.Context, interaction *objects.Interaction, interactionData *objects.ApplicationCommandInteractionData) (*objects.InteractionResponse, error) {
+				t.Error("guild only command was run in a DM")
+				return nil, nil
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	dm := func(name string) *objects.Interaction {
+		return &objects.Interaction{
+			Type: objects.InteractionApplicationCommand,
+			User: &objects.User{ID: 2},
+			Data: []byte(`{"name": "` + name + `", "type": 1}`),
+		}
+	}
+
+	res, err := rtr.handleCommand(context.Background(), dm("register"))
+	require.NoError(t, err)
+	assert.Contains(t, res.(*objects.InteractionResponse).Data.Content, "https://example.com/auth?state=2")
+
+	res, err = rtr.handleCommand(context.Background(), dm("guild-only"))
+	require.NoError(t, err)
+	assert.Equal(t, "This command can only be used in a server", res.(*objects.InteractionResponse).Data.Content)
+}
@@ -3,69 +3,213 @@ package interactions
 import (
 	"context"
 	"fmt"
+	"oscen/repositories/guildmembers"
 	"oscen/repositories/listens"
-	"sort"
-
-	"github.com/Postcord/rest"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Postcord/objects"
 )
 
-// TODO: Introduce caching here :)
+const (
+	leaderboardPageSize = 10
 
-func NewListenLeaderboardInteraction(listensRepo *listens.PostgresRepository, dc *rest.Client) *Interaction {
-	h := func(
+	leaderboardPageComponent = "leaderboard.page"
+)
+
+var leaderboardPeriods = []string{"day", "week", "month", "all"}
+
+// leaderboardButtons lets the owner of a leaderboard page through it. It
+// returns nil when everyone fits on one page.
+func leaderboardButtons(owner string, p period, page int, hasNext bool) ([]*objects.Component, error) {
+	if page == 0 && !hasNext {
+		return nil, nil
+	}
+
+	previousID, err := ownedCustomID(owner, leaderboardPageComponent, p.name, strconv.Itoa(page-1))
+	if err != nil {
+		return nil, err
+	}
+	nextID, err := ownedCustomID(owner, leaderboardPageComponent, p.name, strconv.Itoa(page+1))
+	if err != nil {
+		return nil, err
+	}
+
+	return []*objects.Component{
+		{
+			Type: objects.ComponentTypeActionRow,
+			Components: []*objects.Component{
+				{
+					Type:     objects.ComponentTypeButton,
+					Style:    objects.ButtonStyleSecondary,
+					Label:    "Previous page",
+					CustomID: previousID,
+					Disabled: page == 0,
+				},
+				{
+					Type:     objects.ComponentTypeButton,
+					Style:    objects.ButtonStyleSecondary,
+					Label:    "Next page",
+					CustomID: nextID,
+					Disabled: !hasNext,
+				},
+			},
+		},
+	}, nil
+}
+
+func NewListenLeaderboardInteraction(listensRepo listens.Repository, membersRepo guildmembers.Repository) *Interaction {
+	render := func(
 		ctx context.Context,
 		interaction *objects.Interaction,
-		interactionData *objects.ApplicationCommandInteractionData,
-	) (*objects.InteractionResponse, error) {
-		members, err := dc.ListGuildMembers(interaction.GuildID, &rest.ListGuildMembersParams{
-			Limit: 1000,
-		})
-		// TODO: Pagination
+		p period,
+		page int,
+	) (*objects.InteractionApplicationCommandCallbackData, error) {
+		memberIDs, err := membersRepo.GetMemberIDs(ctx, fmt.Sprintf("%d", interaction.GuildID))
 		if err != nil {
 			return nil, err
 		}
+		if len(memberIDs) == 0 {
+			return &objects.InteractionApplicationCommandCallbackData{
+				Content: membersNotSynced,
+				Flags:   objects.ResponseFlagEphemeral,
+			}, nil
+		}
 
-		type result struct {
-			member  *objects.GuildMember
-			listens int
+		// Ask for one more than fits on the page to find out if there is
+		// another page.
+		entries, err := listensRepo.GetLeaderboard(
+			ctx,
+			memberIDs,
+			p.since(time.Now()),
+			leaderboardPageSize+1,
+			page*leaderboardPageSize,
+		)
+		if err != nil {
+			return nil, err
 		}
 
-		results := []result{}
-		for _, member := range members {
-			discordId := fmt.Sprintf("%d", member.User.ID)
-			count, err := listensRepo.GetUserListenCount(ctx, discordId)
-			if err != nil {
-				return nil, err
-			}
+		hasNext := len(entries) > leaderboardPageSize
+		if hasNext {
+			entries = entries[:leaderboardPageSize]
+		}
+
+		if len(entries) == 0 && page == 0 {
+			return &objects.InteractionApplicationCommandCallbackData{
+				Content: fmt.Sprintf("Nobody here has listened to anything in %s", p.label),
+			}, nil
+		}
 
-			if count != 0 {
-				results = append(results, result{listens: count, member: member})
+		sb := &strings.Builder{}
+		if len(entries) == 0 {
+			sb.WriteString("Nobody else has listened to anything")
+		}
+		for i, entry := range entries {
+			unit := "listens"
+			if entry.Listens == 1 {
+				unit = "listen"
 			}
+			fmt.Fprintf(
+				sb,
+				"%d. <@%s> - %d %s (%s)\n",
+				page*leaderboardPageSize+i+1,
+				entry.DiscordID,
+				entry.Listens,
+				unit,
+				formatDuration(entry.ListeningTime),
+			)
 		}
 
-		// Sort them in descending order.
-		sort.Slice(results, func(i, j int) bool {
-			return results[i].listens > results[j].listens
-		})
+		buttons, err := leaderboardButtons(invokerOf(interaction).DiscordID(), p, page, hasNext)
+		if err != nil {
+			return nil, err
+		}
+
+		return &objects.InteractionApplicationCommandCallbackData{
+			Embeds: []*objects.Embed{
+				{
+					Title:       fmt.Sprintf("Leaderboard for %s", p.label),
+					Description: sb.String(),
+					Footer: &objects.EmbedFooter{
+						Text: fmt.Sprintf("Page %d", page+1),
+					},
+				},
+			},
+			AllowedMentions: noMentions,
+			Components:      buttons,
+		}, nil
+	}
+
+	h := func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		interactionData *objects.ApplicationCommandInteractionData,
+	) (*objects.InteractionResponse, error) {
+		p, _ := findPeriod("week")
+		if name, ok := optionsFor(interactionData).String("period"); ok {
+			if found, ok := findPeriod(name); ok {
+				p = found
+			}
+		}
 
-		msg := "The champion is %s with %d scrobbles!"
+		data, err := render(ctx, interaction, p, 0)
+		if err != nil {
+			return nil, err
+		}
 
 		return &objects.InteractionResponse{
 			Type: objects.ResponseChannelMessageWithSource,
-			Data: &objects.InteractionApplicationCommandCallbackData{
-				Content: fmt.Sprintf(msg, results[0].member.Nick, results[0].listens),
-			},
+			Data: data,
+		}, nil
+	}
+
+	changePage := func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		componentData *objects.ApplicationComponentInteractionData,
+		state []string,
+	) (*objects.InteractionResponse, error) {
+		if len(state) != 2 {
+			return nil, fmt.Errorf("invalid leaderboard page state: %v", state)
+		}
+
+		p, ok := findPeriod(state[0])
+		if !ok {
+			return nil, fmt.Errorf("unknown period: %s", state[0])
+		}
+		page, err := strconv.Atoi(state[1])
+		if err != nil || page < 0 {
+			return nil, fmt.Errorf("invalid leaderboard page: %s", state[1])
+		}
+
+		data, err := render(ctx, interaction, p, page)
+		if err != nil {
+			return nil, err
+		}
+
+		return &objects.InteractionResponse{
+			Type: objects.ResponseUpdateMessage,
+			Data: data,
 		}, nil
 	}
 
 	return &Interaction{
 		ApplicationCommand: &objects.ApplicationCommand{
-			Name:              "np",
-			Description:       "Shows your currently playing track",
+			Name:              "leaderboard",
+			Description:       "Shows who in this server has listened to the most",
 			DefaultPermission: true,
+			Options: []objects.ApplicationCommandOption{
+				periodOption("The time period to look at, defaults to the last week", false, leaderboardPeriods...),
+			},
 		},
 		handler: h,
+		components: []*Component{
+			{
+				Name:    leaderboardPageComponent,
+				handler: changePage,
+				owned:   true,
+			},
+		},
 	}
 }
@@ -0,0 +1,63 @@
+package interactions
+
+import (
+	"context"
+	"oscen/repositories/users"
+
+	"github.com/Postcord/objects"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Middleware wraps a command handler, e.g. to check something before it runs
+// or to add values to its context.
+type Middleware func(next handler) handler
+
+// chain wraps h in mw, with the first middleware outermost.
+func chain(h handler, mw ...Middleware) handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Use adds middleware run around every command handler. It runs before
+// commands are deferred, so should be quick.
+func (r *router) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+type spotifyClientKey struct{}
+
+// spotifyClientFrom returns the invoker's Spotify client added by
+// requireSpotifyLink.
+func spotifyClientFrom(ctx context.Context) *spotify.Client {
+	client, _ := ctx.Value(spotifyClientKey{}).(*spotify.Client)
+	return client
+}
+
+// requireSpotifyLink only runs the handler if the invoker has linked their
+// Spotify account, making their client available with spotifyClientFrom.
+// Errors from the handler caused by the link, such as Spotify revoking it,
+// are turned into a message telling the invoker how to fix it.
+func requireSpotifyLink(userRepo users.Repository, auth spotifyAuth) Middleware {
+	return func(next handler) handler {
+		return func(
+			ctx context.Context,
+			interaction *objects.Interaction,
+			interactionData *objects.ApplicationCommandInteractionData,
+		) (*objects.InteractionResponse, error) {
+			userID := invokerOf(interaction).DiscordID()
+			client, err := ensureSpotifyClient(ctx, userID, userRepo, auth)
+			if err != nil {
+				return linkErrorResponse(ctx, userID, userRepo, err)
+			}
+
+			ctx = context.WithValue(ctx, spotifyClientKey{}, client)
+			res, err := next(ctx, interaction, interactionData)
+			if err != nil {
+				return linkErrorResponse(ctx, userID, userRepo, err)
+			}
+			return res, nil
+		}
+	}
+}
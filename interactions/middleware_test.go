@@ -0,0 +1,65 @@
+package interactions
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Postcord/objects"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// recordingMiddleware appends name to calls before and after the handler.
+func recordingMiddleware(calls *[]string, name string) Middleware {
+	return func(next handler) handler {
+		return func(ctx context.Context, interaction *objects.Interaction, interactionData *objects.ApplicationCommandInteractionData) (*objects.InteractionResponse, error) {
+			*calls = append(*calls, name)
+			res, err := next(ctx, interaction, interactionData)
+			*calls = append(*calls, name+" done")
+			return res, err
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	calls := []string{}
+
+	rtr := NewRouter(zaptest.NewLogger(t), nil, nil)
+	rtr.Use(recordingMiddleware(&calls, "router"))
+	err := rtr.Register(&Interaction{
+		ApplicationCommand: &objects.ApplicationCommand{Name: "test"},
+		handler: func(ctx context.Context, interaction *objects.Interaction, interactionData *objects.ApplicationCommandInteractionData) (*objects.InteractionResponse, error) {
+			calls = append(calls, "handler")
+			return ephemeralResponse("hello"), nil
+		},
+		middleware: []Middleware{
+			recordingMiddleware(&calls, "first"),
+			recordingMiddleware(&calls, "second"),
+		},
+	})
+	require.NoError(t, err)
+
+	res, err := rtr.handleCommand(context.Background(), &objects.Interaction{
+		Type:    objects.InteractionApplicationCommand,
+		GuildID: 1,
+		Member:  &objects.GuildMember{User: &objects.User{ID: 2}},
+		Data:    []byte(`{"name": "test", "type": 1}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", res.(*objects.InteractionResponse).Data.Content)
+
+	assert.Equal(t, []string{
+		"router",
+		"first",
+		"second",
+		"handler",
+		"second done",
+		"first done",
+		"router done",
+	}, calls)
+}
+
+func TestSpotifyClientFromEmptyContext(t *testing.T) {
+	assert.Nil(t, spotifyClientFrom(context.Background()))
+}
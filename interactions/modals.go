@@ -0,0 +1,162 @@
+package interactions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"oscen/tracer"
+
+	"github.com/Postcord/objects"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// Postcord doesn't know about modals yet.
+const (
+	interactionModalSubmit objects.InteractionType = 5
+	responseModal          objects.ResponseType    = 9
+	componentTypeTextInput objects.ComponentType   = 4
+)
+
+type textInputStyle int
+
+const (
+	textInputShort textInputStyle = iota + 1
+	textInputParagraph
+)
+
+type modal struct {
+	CustomID   string     `json:"custom_id"`
+	Title      string     `json:"title"`
+	Components []modalRow `json:"components"`
+}
+
+type modalRow struct {
+	Type       objects.ComponentType `json:"type"`
+	Components []textInput           `json:"components"`
+}
+
+type textInput struct {
+	Type        objects.ComponentType `json:"type"`
+	CustomID    string                `json:"custom_id"`
+	Style       textInputStyle        `json:"style"`
+	Label       string                `json:"label"`
+	MinLength   int                   `json:"min_length,omitempty"`
+	MaxLength   int                   `json:"max_length,omitempty"`
+	Required    bool                  `json:"required"`
+	Value       string                `json:"value,omitempty"`
+	Placeholder string                `json:"placeholder,omitempty"`
+}
+
+// newModal builds a modal with each input on its own row, which is the only
+// layout Discord allows.
+func newModal(customID string, title string, inputs ...textInput) *modal {
+	m := &modal{
+		CustomID: customID,
+		Title:    title,
+	}
+	for _, input := range inputs {
+		input.Type = componentTypeTextInput
+		m.Components = append(m.Components, modalRow{
+			Type:       objects.ComponentTypeActionRow,
+			Components: []textInput{input},
+		})
+	}
+	return m
+}
+
+type modalResponse struct {
+	Type objects.ResponseType `json:"type"`
+	Data *modal               `json:"data"`
+}
+
+// modalOpener is used instead of a handler by commands that ask for their
+// input in a modal.
+type modalOpener = func(
+	ctx context.Context,
+	interaction *objects.Interaction,
+	interactionData *objects.ApplicationCommandInteractionData,
+) (*modal, error)
+
+type modalSubmitHandler = func(
+	ctx context.Context,
+	interaction *objects.Interaction,
+	values map[string]string,
+	state []string,
+) (*objects.InteractionResponse, error)
+
+// ModalSubmit handles modals whose custom_id was built with customID using
+// the same name.
+type ModalSubmit struct {
+	Name     string
+	handler  modalSubmitHandler
+	deferred bool
+}
+
+type modalSubmitData struct {
+	CustomID   string `json:"custom_id"`
+	Components []struct {
+		Components []struct {
+			CustomID string `json:"custom_id"`
+			Value    string `json:"value"`
+		} `json:"components"`
+	} `json:"components"`
+}
+
+// values returns what was entered in each text input, keyed by custom_id.
+func (d *modalSubmitData) values() map[string]string {
+	values := map[string]string{}
+	for _, row := range d.Components {
+		for _, input := range row.Components {
+			values[input.CustomID] = input.Value
+		}
+	}
+	return values
+}
+
+// deferModalSubmitHandler is deferHandler for modal submits.
+func (r *router) deferModalSubmitHandler(h modalSubmitHandler) modalSubmitHandler {
+	return func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		values map[string]string,
+		state []string,
+	) (*objects.InteractionResponse, error) {
+		r.runInBackground(ctx, interaction, func(ctx context.Context) (*objects.InteractionResponse, error) {
+			return h(ctx, interaction, values, state)
+		})
+
+		return deferredResponse(), nil
+	}
+}
+
+func (r *router) handleModalSubmit(ctx context.Context, interaction *objects.Interaction) (*objects.InteractionResponse, error) {
+	r.log.Debug("interaction.handle_modal_submit", zap.Any("data", interaction))
+	ctx, childSpan := tracer.Start(ctx, "interactions.handle_modal_submit")
+	defer childSpan.End()
+
+	submitData := &modalSubmitData{}
+	err := json.Unmarshal(interaction.Data, submitData)
+	if err != nil {
+		return nil, err
+	}
+
+	name, state, err := parseCustomID(submitData.CustomID)
+	if err != nil {
+		return nil, err
+	}
+
+	childSpan.SetAttributes(
+		attribute.String("io.oscen.modal_name", name),
+		attribute.String("io.oscen.discord_user", invokerOf(interaction).DiscordID()),
+	)
+
+	handler, ok := r.modalSubmitRoutes[name]
+	if !ok {
+		return nil, fmt.Errorf(
+			"cannot find handler for modal: %s", name,
+		)
+	}
+
+	return handler(ctx, interaction, submitData.values(), state)
+}
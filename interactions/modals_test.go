@@ -0,0 +1,95 @@
+package interactions
+
+import (
+	"context"
+	"testing"
+
+	"oscen/playlistcreator"
+
+	"github.com/Postcord/objects"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zmb3/spotify/v2"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestModalRoundTrip(t *testing.T) {
+	rtr := NewRouter(zaptest.NewLogger(t), nil, nil)
+
+	var gotValues map[string]string
+	var gotState []string
+	err := rtr.Register(&Interaction{
+		ApplicationCommand: &objects.ApplicationCommand{Name: "test"},
+		modal: func(ctx context.Context, interaction *objects.Interaction, interactionData *objects.ApplicationCommandInteractionData) (*modal, error) {
+			id, err := customID("test.modal", "state")
+			if err != nil {
+				return nil, err
+			}
+			return newModal(id, "Test", textInput{CustomID: "name", Label: "Name"}), nil
+		},
+		modalSubmits: []*ModalSubmit{
+			{
+				Name: "test.modal",
+				handler: func(ctx context.Context, interaction *objects.Interaction, values map[string]string, state []string) (*objects.InteractionResponse, error) {
+					gotValues = values
+					gotState = state
+					return ephemeralResponse("done"), nil
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	member := &objects.GuildMember{User: &objects.User{ID: 1}}
+	res, err := rtr.handleCommand(context.Background(), &objects.Interaction{
+		Type:   objects.InteractionApplicationCommand,
+		Member: member,
+		Data:   []byte(`{"name": "test"}`),
+	})
+	require.NoError(t, err)
+	require.IsType(t, &modalResponse{}, res)
+	m := res.(*modalResponse)
+	assert.Equal(t, responseModal, m.Type)
+	assert.Equal(t, "test.modal:state", m.Data.CustomID)
+	assert.Equal(t, componentTypeTextInput, m.Data.Components[0].Components[0].Type)
+
+	_, err = rtr.handleModalSubmit(context.Background(), &objects.Interaction{
+		Type:   interactionModalSubmit,
+		Member: member,
+		Data: []byte(`{"custom_id": "test.modal:state", "components": [
+			{"type": 1, "components": [{"type": 4, "custom_id": "name", "value": "Road trip"}]}
+		]}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "Road trip"}, gotValues)
+	assert.Equal(t, []string{"state"}, gotState)
+}
+
+func TestParsePlaylistOptions(t *testing.T) {
+	opts, err := parsePlaylistOptions(map[string]string{
+		"name":       " Road trip ",
+		"size":       "40",
+		"time_range": "Long",
+		"visibility": "private",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, playlistcreator.Options{
+		Name:      "Road trip",
+		Size:      40,
+		TimeRange: spotify.LongTermRange,
+		Private:   true,
+	}, opts)
+
+	opts, err = parsePlaylistOptions(map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, playlistcreator.Options{}, opts)
+
+	_, err = parsePlaylistOptions(map[string]string{"size": "500"})
+	assert.EqualError(t, err, "The size must be a number between 1 and 99")
+
+	_, err = parsePlaylistOptions(map[string]string{"time_range": "decade"})
+	assert.EqualError(t, err, "The time range must be short, medium or long")
+
+	_, err = parsePlaylistOptions(map[string]string{"visibility": "secret"})
+	assert.EqualError(t, err, "The visibility must be public or private")
+}
@@ -10,53 +10,115 @@ import (
 	"github.com/Postcord/objects"
 
 	"github.com/zmb3/spotify/v2"
-	spotifyauth "github.com/zmb3/spotify/v2/auth"
 )
 
 func ensureSpotifyClient(
 	ctx context.Context,
-	i *objects.Interaction,
-	userRepo *users.PostgresRepository,
-	auth *spotifyauth.Authenticator,
+	discordID string,
+	userRepo users.Repository,
+	auth spotifyAuth,
 ) (*spotify.Client, error) {
 	ctx, childSpan := tracer.Start(ctx, "interactions.helper.ensure_spotify_client")
 	defer childSpan.End()
 
-	userId := fmt.Sprintf("%d", i.Member.User.ID)
-	usr, err := userRepo.GetUserByDiscordID(ctx, userId)
+	usr, err := userRepo.GetUserByDiscordID(ctx, discordID)
 	if err != nil {
 		return nil, err
 	}
 
-	return usr.SpotifyClient(ctx, auth), nil
+	if usr.Status == users.LinkStatusRevoked {
+		return nil, users.ErrLinkRevoked
+	}
+
+	return usr.SpotifyClient(ctx, auth, userRepo), nil
+}
+
+type linkState int
+
+const (
+	linkUnaffected linkState = iota
+	linkMissing
+	linkRevoked
+)
+
+// checkLinkError works out whether err was caused by a missing or broken
+// Spotify link, marking links Spotify says are revoked. Any other error is
+// returned with linkUnaffected.
+func checkLinkError(
+	ctx context.Context,
+	discordID string,
+	userRepo users.Repository,
+	err error,
+) (linkState, error) {
+	switch {
+	case err == users.ErrUserNotRegistered:
+		return linkMissing, nil
+	case err == users.ErrLinkRevoked:
+		return linkRevoked, nil
+	case users.IsRevokedGrant(err):
+		_, err := userRepo.SetLinkStatus(ctx, discordID, users.LinkStatusRevoked)
+		if err != nil {
+			return linkUnaffected, err
+		}
+		return linkRevoked, nil
+	default:
+		return linkUnaffected, err
+	}
+}
+
+// linkErrorResponse converts errors caused by the invoker's Spotify link into
+// a message telling them how to fix it. Any other error is returned as is.
+func linkErrorResponse(
+	ctx context.Context,
+	discordID string,
+	userRepo users.Repository,
+	err error,
+) (*objects.InteractionResponse, error) {
+	state, err := checkLinkError(ctx, discordID, userRepo, err)
+	switch {
+	case err != nil:
+		return nil, err
+	case state == linkMissing:
+		return ephemeralResponse("You need to use /register before you can use other commands"), nil
+	default:
+		return ephemeralResponse("Oscen can no longer access your Spotify account, use /register to link it again"), nil
+	}
 }
 
-func NewNowPlayingInteraction(userRepo *users.PostgresRepository, auth *spotifyauth.Authenticator, listensRepo *listens.PostgresRepository) *Interaction {
+// targetLinkErrorResponse is linkErrorResponse for when the link belongs to
+// someone other than the invoker.
+func targetLinkErrorResponse(
+	ctx context.Context,
+	discordID string,
+	name string,
+	userRepo users.Repository,
+	err error,
+) (*objects.InteractionResponse, error) {
+	state, err := checkLinkError(ctx, discordID, userRepo, err)
+	switch {
+	case err != nil:
+		return nil, err
+	case state == linkMissing:
+		return ephemeralResponse(fmt.Sprintf("%s hasn't linked their Spotify account to Oscen", name)), nil
+	default:
+		return ephemeralResponse(fmt.Sprintf("Oscen can no longer access %s's Spotify account", name)), nil
+	}
+}
+
+func NewNowPlayingInteraction(userRepo users.Repository, auth spotifyAuth, listensRepo listens.Repository) *Interaction {
 	h := func(
 		ctx context.Context,
 		interaction *objects.Interaction,
 		interactionData *objects.ApplicationCommandInteractionData,
 	) (*objects.InteractionResponse, error) {
-		client, err := ensureSpotifyClient(ctx, interaction, userRepo, auth)
-		if err != nil {
-			if err == users.ErrUserNotRegistered {
-				return &objects.InteractionResponse{
-					Type: objects.ResponseChannelMessageWithSource,
-					Data: &objects.InteractionApplicationCommandCallbackData{
-						Content: "You need to use /register before you can use other commands",
-					},
-				}, nil
-			}
-
-			return nil, err
-		}
-
-		np, err := client.PlayerCurrentlyPlaying(ctx)
+		userID := invokerOf(interaction).DiscordID()
+		np, err := spotifyClientFrom(ctx).PlayerCurrentlyPlaying(ctx)
 		if err != nil {
 			return nil, err
 		}
 
-		if np == nil {
+		// Spotify responds with no content rather than an empty item.
+		if np == nil || np.Item == nil {
 			return &objects.InteractionResponse{
 				Type: objects.ResponseChannelMessageWithSource,
 				Data: &objects.InteractionApplicationCommandCallbackData{
@@ -65,7 +127,6 @@ func NewNowPlayingInteraction(userRepo *users.PostgresRepository, auth *spotifya
 			}, nil
 		}
 
-		userID := fmt.Sprintf("%d", interaction.Member.User.ID)
 		songListens, err := listensRepo.GetSongListenCount(ctx, userID, string(np.Item.ID))
 		if err != nil {
 			return nil, err
@@ -98,6 +159,8 @@ func NewNowPlayingInteraction(userRepo *users.PostgresRepository, auth *spotifya
 			Description:       "Shows your currently playing track",
 			DefaultPermission: true,
 		},
-		handler: h,
+		handler:    h,
+		dmCapable:  true,
+		middleware: []Middleware{requireSpotifyLink(userRepo, auth)},
 	}
 }
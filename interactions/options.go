@@ -0,0 +1,201 @@
+package interactions
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Postcord/objects"
+)
+
+// optionError is returned when the options a user gave don't match the
+// command definition. The message is shown to the user.
+type optionError struct {
+	msg string
+}
+
+func (e *optionError) Error() string {
+	return e.msg
+}
+
+func optionErrorf(format string, args ...interface{}) *optionError {
+	return &optionError{msg: fmt.Sprintf(format, args...)}
+}
+
+// resolveCommandPath walks down any subcommand group and subcommand in the
+// interaction, returning the full path used to route it (e.g. "stats
+// top-artists"), along with the options and definitions of the leaf.
+func resolveCommandPath(
+	data *objects.ApplicationCommandInteractionData,
+	defs []objects.ApplicationCommandOption,
+) (string, []*objects.ApplicationCommandInteractionDataOption, []objects.ApplicationCommandOption) {
+	path := []string{data.Name}
+	opts := data.Options
+
+	for len(opts) == 1 && isSubcommand(objects.ApplicationCommandOptionType(opts[0].Type)) {
+		path = append(path, opts[0].Name)
+
+		var subDefs []objects.ApplicationCommandOption
+		for _, def := range defs {
+			if def.Name == opts[0].Name {
+				subDefs = def.Options
+				break
+			}
+		}
+
+		defs = subDefs
+		opts = opts[0].Options
+	}
+
+	return strings.Join(path, " "), opts, defs
+}
+
+func isSubcommand(t objects.ApplicationCommandOptionType) bool {
+	return t == objects.TypeSubCommand || t == objects.TypeSubCommandGroup
+}
+
+// validateOptions checks the options given by the user against the command
+// definition. Discord enforces most of this, but we'd rather give a useful
+// message than trust it.
+func validateOptions(
+	defs []objects.ApplicationCommandOption,
+	opts []*objects.ApplicationCommandInteractionDataOption,
+) error {
+	given := map[string]*objects.ApplicationCommandInteractionDataOption{}
+	for _, opt := range opts {
+		given[opt.Name] = opt
+	}
+
+	known := map[string]bool{}
+	for _, def := range defs {
+		known[def.Name] = true
+
+		opt, ok := given[def.Name]
+		if !ok {
+			if def.Required {
+				return optionErrorf("The `%s` option is required", def.Name)
+			}
+			continue
+		}
+
+		if err := validateOptionValue(def, opt); err != nil {
+			return err
+		}
+	}
+
+	for _, opt := range opts {
+		if !known[opt.Name] {
+			return optionErrorf("Unknown option `%s`", opt.Name)
+		}
+	}
+
+	return nil
+}
+
+func validateOptionValue(def objects.ApplicationCommandOption, opt *objects.ApplicationCommandInteractionDataOption) error {
+	switch def.OptionType {
+	case objects.TypeString:
+		if _, ok := opt.Value.(string); !ok {
+			return optionErrorf("The `%s` option must be text", def.Name)
+		}
+	case objects.TypeInteger:
+		f, ok := opt.Value.(float64)
+		if !ok || f != math.Trunc(f) {
+			return optionErrorf("The `%s` option must be a whole number", def.Name)
+		}
+	case objects.TypeDouble:
+		if _, ok := opt.Value.(float64); !ok {
+			return optionErrorf("The `%s` option must be a number", def.Name)
+		}
+	case objects.TypeBoolean:
+		if _, ok := opt.Value.(bool); !ok {
+			return optionErrorf("The `%s` option must be true or false", def.Name)
+		}
+	case objects.TypeUser, objects.TypeChannel, objects.TypeRole, objects.TypeMentionable:
+		s, ok := opt.Value.(string)
+		if !ok {
+			return optionErrorf("The `%s` option is invalid", def.Name)
+		}
+		if _, err := strconv.ParseUint(s, 10, 64); err != nil {
+			return optionErrorf("The `%s` option is invalid", def.Name)
+		}
+	}
+
+	if len(def.Choices) == 0 {
+		return nil
+	}
+	for _, choice := range def.Choices {
+		if fmt.Sprint(choice.Value) == fmt.Sprint(opt.Value) {
+			return nil
+		}
+	}
+	return optionErrorf("`%v` isn't a valid choice for the `%s` option", opt.Value, def.Name)
+}
+
+// commandOptions gives handlers typed access to the options of the
+// (sub)command they were invoked with.
+type commandOptions struct {
+	options  map[string]*objects.ApplicationCommandInteractionDataOption
+	resolved objects.ApplicationCommandInteractionDataResolved
+}
+
+func optionsFor(data *objects.ApplicationCommandInteractionData) commandOptions {
+	_, opts, _ := resolveCommandPath(data, nil)
+
+	co := commandOptions{
+		options:  map[string]*objects.ApplicationCommandInteractionDataOption{},
+		resolved: data.Resolved,
+	}
+	for _, opt := range opts {
+		co.options[opt.Name] = opt
+	}
+
+	return co
+}
+
+func (co commandOptions) String(name string) (string, bool) {
+	opt, ok := co.options[name]
+	if !ok {
+		return "", false
+	}
+	s, ok := opt.Value.(string)
+	return s, ok
+}
+
+func (co commandOptions) Int(name string) (int64, bool) {
+	opt, ok := co.options[name]
+	if !ok {
+		return 0, false
+	}
+	f, ok := opt.Value.(float64)
+	return int64(f), ok
+}
+
+func (co commandOptions) Bool(name string) (bool, bool) {
+	opt, ok := co.options[name]
+	if !ok {
+		return false, false
+	}
+	b, ok := opt.Value.(bool)
+	return b, ok
+}
+
+// User returns the user given for the option, using the data Discord
+// resolved for it.
+func (co commandOptions) User(name string) (*objects.User, bool) {
+	s, ok := co.String(name)
+	if !ok {
+		return nil, false
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	usr, ok := co.resolved.Users[objects.Snowflake(id)]
+	if !ok {
+		return &objects.User{ID: objects.Snowflake(id)}, true
+	}
+	return &usr, true
+}
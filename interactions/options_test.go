@@ -0,0 +1,151 @@
+package interactions
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Postcord/objects"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testCommand = &objects.ApplicationCommand{
+	Name: "stats",
+	Options: []objects.ApplicationCommandOption{
+		{
+			OptionType: objects.TypeSubCommandGroup,
+			Name:       "top",
+			Options: []objects.ApplicationCommandOption{
+				{
+					OptionType: objects.TypeSubCommand,
+					Name:       "artists",
+					Options: []objects.ApplicationCommandOption{
+						{
+							OptionType: objects.TypeString,
+							Name:       "period",
+							Required:   true,
+							Choices: []objects.ApplicationCommandOptionChoice{
+								{Name: "week", Value: "week"},
+								{Name: "month", Value: "month"},
+							},
+						},
+						{OptionType: objects.TypeInteger, Name: "count"},
+						{OptionType: objects.TypeUser, Name: "user"},
+					},
+				},
+			},
+		},
+	},
+}
+
+func parseCommandData(t *testing.T, raw string) *objects.ApplicationCommandInteractionData {
+	t.Helper()
+
+	data := &objects.ApplicationCommandInteractionData{}
+	require.NoError(t, json.Unmarshal([]byte(raw), data))
+	return data
+}
+
+func TestResolveCommandPath(t *testing.T) {
+	data := parseCommandData(t, `{
+		"name": "stats",
+		"options": [{"type": 2, "name": "top", "options": [
+			{"type": 1, "name": "artists", "options": [
+				{"type": 3, "name": "period", "value": "week"}
+			]}
+		]}]
+	}`)
+
+	path, opts, defs := resolveCommandPath(data, testCommand.Options)
+
+	assert.Equal(t, "stats top artists", path)
+	require.Len(t, opts, 1)
+	assert.Equal(t, "period", opts[0].Name)
+	assert.Len(t, defs, 3)
+}
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			options: `[{"type": 3, "name": "period", "value": "month"}, {"type": 4, "name": "count", "value": 5}]`,
+		},
+		{
+			name:    "missing required",
+			options: `[{"type": 4, "name": "count", "value": 5}]`,
+			wantErr: "The `period` option is required",
+		},
+		{
+			name:    "invalid choice",
+			options: `[{"type": 3, "name": "period", "value": "decade"}]`,
+			wantErr: "`decade` isn't a valid choice for the `period` option",
+		},
+		{
+			name:    "non integer",
+			options: `[{"type": 3, "name": "period", "value": "week"}, {"type": 4, "name": "count", "value": 1.5}]`,
+			wantErr: "The `count` option must be a whole number",
+		},
+		{
+			name:    "invalid user",
+			options: `[{"type": 3, "name": "period", "value": "week"}, {"type": 6, "name": "user", "value": "bob"}]`,
+			wantErr: "The `user` option is invalid",
+		},
+		{
+			name:    "unknown option",
+			options: `[{"type": 3, "name": "period", "value": "week"}, {"type": 3, "name": "colour", "value": "red"}]`,
+			wantErr: "Unknown option `colour`",
+		},
+	}
+
+	defs := testCommand.Options[0].Options[0].Options
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []*objects.ApplicationCommandInteractionDataOption{}
+			require.NoError(t, json.Unmarshal([]byte(tt.options), &opts))
+
+			err := validateOptions(defs, opts)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCommandOptions(t *testing.T) {
+	data := parseCommandData(t, `{
+		"name": "stats",
+		"options": [{"type": 2, "name": "top", "options": [
+			{"type": 1, "name": "artists", "options": [
+				{"type": 3, "name": "period", "value": "week"},
+				{"type": 4, "name": "count", "value": 5},
+				{"type": 6, "name": "user", "value": "1234"}
+			]}
+		]}],
+		"resolved": {"users": {"1234": {"id": "1234", "username": "noah"}}}
+	}`)
+
+	opts := optionsFor(data)
+
+	period, ok := opts.String("period")
+	assert.True(t, ok)
+	assert.Equal(t, "week", period)
+
+	count, ok := opts.Int("count")
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), count)
+
+	usr, ok := opts.User("user")
+	require.True(t, ok)
+	assert.Equal(t, objects.Snowflake(1234), usr.ID)
+	assert.Equal(t, "noah", usr.Username)
+
+	_, ok = opts.Bool("missing")
+	assert.False(t, ok)
+}
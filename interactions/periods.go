@@ -0,0 +1,78 @@
+package interactions
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Postcord/objects"
+)
+
+// period is a window of time users can ask for stats over.
+type period struct {
+	name  string
+	label string
+	// duration is how far back the period goes, zero means all time.
+	duration time.Duration
+}
+
+var periods = []period{
+	{name: "day", label: "the last day", duration: 24 * time.Hour},
+	{name: "week", label: "the last week", duration: 7 * 24 * time.Hour},
+	{name: "month", label: "the last month", duration: 30 * 24 * time.Hour},
+	{name: "year", label: "the last year", duration: 365 * 24 * time.Hour},
+	{name: "all", label: "all time"},
+}
+
+func findPeriod(name string) (period, bool) {
+	for _, p := range periods {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return period{}, false
+}
+
+// since returns the start of the period ending at now.
+func (p period) since(now time.Time) time.Time {
+	if p.duration == 0 {
+		return time.Unix(0, 0)
+	}
+	return now.Add(-p.duration)
+}
+
+// periodOption builds a choice option for the named periods.
+func periodOption(description string, required bool, names ...string) objects.ApplicationCommandOption {
+	opt := objects.ApplicationCommandOption{
+		OptionType:  objects.TypeString,
+		Name:        "period",
+		Description: description,
+		Required:    required,
+	}
+	for _, name := range names {
+		p, _ := findPeriod(name)
+		opt.Choices = append(opt.Choices, objects.ApplicationCommandOptionChoice{
+			Name:  p.label,
+			Value: p.name,
+		})
+	}
+	return opt
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// formatDuration renders a listening time like "3h 25m".
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	if h == 0 {
+		return fmt.Sprintf("%dm", m)
+	}
+	return fmt.Sprintf("%dh %dm", h, m)
+}
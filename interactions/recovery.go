@@ -0,0 +1,69 @@
+package interactions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/Postcord/objects"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// panicError is a recovered panic.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// recovered runs fn, converting a panic into an error so a broken handler
+// can't take down the process.
+func recovered(fn func() (interface{}, error)) (res interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			res = nil
+			err = &panicError{value: p, stack: debug.Stack()}
+		}
+	}()
+
+	return fn()
+}
+
+// failed records err against the current span and returns a response telling
+// the user something went wrong, with the trace ID so we can find out what.
+func (r *router) failed(ctx context.Context, err error) *objects.InteractionResponse {
+	span := trace.SpanFromContext(ctx)
+	sc := span.SpanContext()
+
+	fields := []zap.Field{zap.Error(err)}
+	var opts []trace.EventOption
+	var pe *panicError
+	if errors.As(err, &pe) {
+		fields = append(fields, zap.ByteString("stack", pe.stack))
+		opts = append(opts, trace.WithAttributes(attribute.String("exception.stacktrace", string(pe.stack))))
+	}
+	if sc.HasTraceID() {
+		fields = append(fields, zap.String("trace_id", sc.TraceID().String()))
+	}
+
+	span.RecordError(err, opts...)
+	span.SetStatus(codes.Error, err.Error())
+	r.log.Error("failed to handle interaction", fields...)
+
+	return errorResponse(sc)
+}
+
+func errorResponse(sc trace.SpanContext) *objects.InteractionResponse {
+	msg := "Sorry, something went wrong."
+	if sc.HasTraceID() {
+		msg += fmt.Sprintf(" If it keeps happening, let us know the error ID `%s`.", sc.TraceID())
+	}
+	return ephemeralResponse(msg)
+}
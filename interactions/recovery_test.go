@@ -0,0 +1,115 @@
+package interactions
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Postcord/objects"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestRecovered(t *testing.T) {
+	res, err := recovered(func() (interface{}, error) {
+		return "ok", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", res)
+
+	res, err = recovered(func() (interface{}, error) {
+		var m map[string]int
+		m["boom"] = 1
+		return "unreachable", nil
+	})
+	assert.Nil(t, res)
+	var pe *panicError
+	require.True(t, errors.As(err, &pe))
+	assert.Contains(t, pe.Error(), "assignment to entry in nil map")
+	assert.Contains(t, string(pe.stack), "TestRecovered")
+}
+
+func TestHandlerFailures(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	rtr := NewRouter(zaptest.NewLogger(t), pub, nil)
+	err = rtr.Register(
+		&Interaction{
+			ApplicationCommand: &objects.ApplicationCommand{Name: "panics"},
+			handler: func(ctx context.Context, interaction *objects.Interaction, interactionData *objects.ApplicationCommandInteractionData) (*objects.InteractionResponse, error) {
+				panic("oh no")
+			},
+			autocomplete: map[string]autocompleteHandler{
+				"query": func(ctx context.Context, interaction *objects.Interaction, value string) ([]objects.ApplicationCommandOptionChoice, error) {
+					panic("oh no")
+				},
+			},
+		},
+		&Interaction{
+			ApplicationCommand: &objects.ApplicationCommand{Name: "errors"},
+			handler: func(ctx context.Context, interaction *objects.Interaction, interactionData *objects.ApplicationCommandInteractionData) (*objects.InteractionResponse, error) {
+				return nil, errors.New("database on fire")
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	tp := sdktrace.NewTracerProvider()
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	for i, name := range []string{"panics", "errors"} {
+		id := objects.Snowflake(i + 1)
+		t.Run(name, func(t *testing.T) {
+			ctx, span := tp.Tracer("test").Start(context.Background(), "request")
+			defer span.End()
+
+			req := signedRequest(t, priv, &objects.Interaction{
+				ID:      id,
+				Type:    objects.InteractionApplicationCommand,
+				GuildID: 1,
+				Member:  &objects.GuildMember{User: &objects.User{ID: 2}},
+				Data:    []byte(`{"name": "` + name + `", "type": 1}`),
+			}).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			rtr.ServeHTTP(rec, req)
+
+			require.Equal(t, http.StatusOK, rec.Code)
+			res := &objects.InteractionResponse{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), res))
+			assert.Equal(t, objects.ResponseFlagEphemeral, res.Data.Flags)
+			assert.Contains(t, res.Data.Content, "Sorry, something went wrong.")
+			assert.Contains(t, res.Data.Content, span.SpanContext().TraceID().String())
+			assert.NotContains(t, res.Data.Content, "oh no")
+			assert.NotContains(t, res.Data.Content, "database on fire")
+		})
+	}
+
+	t.Run("autocomplete", func(t *testing.T) {
+		req := signedRequest(t, priv, &objects.Interaction{
+			ID:      3,
+			Type:    interactionAutocomplete,
+			GuildID: 1,
+			Member:  &objects.GuildMember{User: &objects.User{ID: 2}},
+			Data:    []byte(`{"name": "panics", "options": [{"name": "query", "type": 3, "value": "a", "focused": true}]}`),
+		})
+		rec := httptest.NewRecorder()
+		rtr.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"type": 8, "data": {"choices": []}}`, rec.Body.String())
+	})
+}
+
+func TestErrorResponseWithoutTrace(t *testing.T) {
+	res := errorResponse(trace.SpanContext{})
+	assert.Equal(t, "Sorry, something went wrong.", res.Data.Content)
+}
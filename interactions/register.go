@@ -3,6 +3,7 @@ package interactions
 import (
 	"context"
 	"fmt"
+	"oscen/repositories/users"
 
 	"github.com/Postcord/objects"
 
@@ -13,17 +14,33 @@ type authURLProvider interface {
 	AuthURL(string, ...oauth2.AuthCodeOption) string
 }
 
+// spotifyAuth is satisfied by *spotifyauth.Authenticator.
+type spotifyAuth interface {
+	authURLProvider
+	users.SpotifyAuth
+}
+
 func NewRegisterInteraction(auth authURLProvider) *Interaction {
 	h := func(
 		ctx context.Context,
 		interaction *objects.Interaction,
 		interactionData *objects.ApplicationCommandInteractionData,
 	) (*objects.InteractionResponse, error) {
-		url := auth.AuthURL(fmt.Sprintf("%d", interaction.Member.User.ID))
+		inv := invokerOf(interaction)
+		url := auth.AuthURL(inv.DiscordID())
+
+		content := fmt.Sprintf("Howdy! Visit %s to register!", url)
+		if !inv.InGuild() {
+			content = fmt.Sprintf(
+				"Howdy! Visit %s to register. Once you're done you can use Oscen here, or in any server you share with it.",
+				url,
+			)
+		}
+
 		return &objects.InteractionResponse{
 			Type: objects.ResponseChannelMessageWithSource,
 			Data: &objects.InteractionApplicationCommandCallbackData{
-				Content: fmt.Sprintf("Howdy! Visit %s to register!", url),
+				Content: content,
 				Flags:   objects.ResponseFlagEphemeral,
 			}}, nil
 	}
@@ -34,6 +51,7 @@ func NewRegisterInteraction(auth authURLProvider) *Interaction {
 			Description:       "Links your spotify account to your discord account",
 			DefaultPermission: true,
 		},
-		handler: h,
+		handler:   h,
+		dmCapable: true,
 	}
 }
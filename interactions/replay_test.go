@@ -0,0 +1,169 @@
+package interactions
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Postcord/objects"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// signedRequest builds an interaction request signed with key, the way
+// Discord would.
+func signedRequest(t *testing.T, key ed25519.PrivateKey, interaction *objects.Interaction) *http.Request {
+	return signedRequestAt(t, key, interaction, time.Now())
+}
+
+func signedRequestAt(t *testing.T, key ed25519.PrivateKey, interaction *objects.Interaction, at time.Time) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(interaction)
+	require.NoError(t, err)
+
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	sig := ed25519.Sign(key, append([]byte(timestamp), body...))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/discord/interactions", bytes.NewReader(body))
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(sig))
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	return req
+}
+
+type failingSeenInteractions struct{}
+
+func (failingSeenInteractions) MarkSeen(context.Context, string, time.Time) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func TestReplayProtection(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	calls := 0
+	newRouter := func(t *testing.T) *router {
+		rtr := NewRouter(zaptest.NewLogger(t), pub, nil)
+		err := rtr.Register(&Interaction{
+			ApplicationCommand: &objects.ApplicationCommand{Name: "test"},
+			handler: func(ctx context.Context, interaction *objects.Interaction, interactionData *objects.ApplicationCommandInteractionData) (*objects.InteractionResponse, error) {
+				calls++
+				return ephemeralResponse("hello"), nil
+			},
+		})
+		require.NoError(t, err)
+		return rtr
+	}
+
+	command := func(id objects.Snowflake) *objects.Interaction {
+		return &objects.Interaction{
+			ID:      id,
+			Type:    objects.InteractionApplicationCommand,
+			GuildID: 1,
+			Member:  &objects.GuildMember{User: &objects.User{ID: 2}},
+			Data:    []byte(`{"name": "test", "type": 1}`),
+		}
+	}
+
+	serve := func(rtr *router, req *http.Request) int {
+		rec := httptest.NewRecorder()
+		rtr.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("ping", func(t *testing.T) {
+		rtr := newRouter(t)
+		rec := httptest.NewRecorder()
+		rtr.ServeHTTP(rec, signedRequest(t, priv, &objects.Interaction{ID: 1, Type: objects.InteractionRequestPing}))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"type": 1}`, rec.Body.String())
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		rtr := newRouter(t)
+		assert.Equal(t, http.StatusUnauthorized, serve(rtr, signedRequest(t, otherPriv, command(1))))
+	})
+
+	t.Run("tampered body", func(t *testing.T) {
+		rtr := newRouter(t)
+		req := signedRequest(t, priv, command(1))
+		tampered := signedRequest(t, priv, command(2))
+		req.Body = tampered.Body
+		assert.Equal(t, http.StatusUnauthorized, serve(rtr, req))
+	})
+
+	t.Run("timestamp skew", func(t *testing.T) {
+		rtr := newRouter(t)
+		now := time.Now()
+		rtr.now = func() time.Time { return now }
+
+		tests := []struct {
+			name string
+			at   time.Time
+			want int
+		}{
+			{name: "too old", at: now.Add(-maxTimestampSkew - time.Second), want: http.StatusUnauthorized},
+			{name: "too new", at: now.Add(maxTimestampSkew + time.Second), want: http.StatusUnauthorized},
+			{name: "recent", at: now.Add(-maxTimestampSkew + time.Second), want: http.StatusOK},
+			{name: "clock behind", at: now.Add(maxTimestampSkew - time.Second), want: http.StatusOK},
+		}
+		for i, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				req := signedRequestAt(t, priv, command(objects.Snowflake(i+1)), tt.at)
+				assert.Equal(t, tt.want, serve(rtr, req))
+			})
+		}
+	})
+
+	t.Run("malformed timestamp", func(t *testing.T) {
+		rtr := newRouter(t)
+		body := []byte(`{"id": "1", "type": 1}`)
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(priv, append([]byte("yesterday"), body...))))
+		req.Header.Set("X-Signature-Timestamp", "yesterday")
+		assert.Equal(t, http.StatusUnauthorized, serve(rtr, req))
+	})
+
+	t.Run("duplicate delivery", func(t *testing.T) {
+		rtr := newRouter(t)
+		calls = 0
+
+		req := signedRequest(t, priv, command(1))
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		replay := func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+			r.Header = req.Header.Clone()
+			return r
+		}
+
+		assert.Equal(t, http.StatusOK, serve(rtr, replay()))
+		assert.Equal(t, http.StatusConflict, serve(rtr, replay()))
+		assert.Equal(t, 1, calls)
+
+		// A different interaction is fine.
+		assert.Equal(t, http.StatusOK, serve(rtr, signedRequest(t, priv, command(2))))
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("store unavailable", func(t *testing.T) {
+		rtr := newRouter(t)
+		rtr.SetSeenInteractions(failingSeenInteractions{})
+		calls = 0
+
+		assert.Equal(t, http.StatusOK, serve(rtr, signedRequest(t, priv, command(1))))
+		assert.Equal(t, 1, calls)
+	})
+}
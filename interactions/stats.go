@@ -0,0 +1,370 @@
+package interactions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oscen/repositories/catalog"
+	"oscen/repositories/listens"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Postcord/objects"
+)
+
+const (
+	defaultStatsCount = 10
+	maxStatsCount     = 25
+
+	statsPeriodComponent = "stats.period"
+)
+
+var statsPeriods = []string{"week", "month", "year", "all"}
+
+var statsOptions = []objects.ApplicationCommandOption{
+	periodOption("The time period to look at", false, statsPeriods...),
+	{
+		OptionType:  objects.TypeInteger,
+		Name:        "count",
+		Description: fmt.Sprintf("How many to show, up to %d", maxStatsCount),
+	},
+	{
+		OptionType:  objects.TypeUser,
+		Name:        "user",
+		Description: "Whose stats to show, defaults to you",
+	},
+}
+
+// statsRequest is everything needed to render a /stats response, so it can
+// be rebuilt from a component's state.
+type statsRequest struct {
+	subcommand string
+	discordID  string
+	mention    string
+	period     period
+	count      int
+	// artistID is only used by the artist subcommand.
+	artistID string
+}
+
+func parseStatsRequest(
+	subcommand string,
+	interaction *objects.Interaction,
+	data *objects.ApplicationCommandInteractionData,
+) statsRequest {
+	opts := optionsFor(data)
+
+	req := statsRequest{
+		subcommand: subcommand,
+		discordID:  invokerOf(interaction).DiscordID(),
+		mention:    "you",
+		count:      defaultStatsCount,
+	}
+	req.period, _ = findPeriod("month")
+
+	if name, ok := opts.String("period"); ok {
+		if p, ok := findPeriod(name); ok {
+			req.period = p
+		}
+	}
+
+	if count, ok := opts.Int("count"); ok && count > 0 {
+		req.count = int(count)
+		if req.count > maxStatsCount {
+			req.count = maxStatsCount
+		}
+	}
+
+	if artistID, ok := opts.String("name"); ok {
+		req.artistID = artistID
+	}
+
+	if usr, ok := opts.User("user"); ok {
+		req.discordID = fmt.Sprintf("%d", usr.ID)
+		req.mention = fmt.Sprintf("<@%d>", usr.ID)
+	}
+
+	return req
+}
+
+// periodMenu lets the owner of a /stats response switch its period without
+// running the command again.
+func (req statsRequest) periodMenu(owner string) (*objects.Component, error) {
+	id, err := ownedCustomID(owner, statsPeriodComponent, req.subcommand, req.discordID, strconv.Itoa(req.count), req.artistID)
+	if err != nil {
+		return nil, err
+	}
+
+	menu := &objects.Component{
+		Type:     objects.ComponentTypeSelectMenu,
+		CustomID: id,
+	}
+	for _, name := range statsPeriods {
+		p, _ := findPeriod(name)
+		menu.Options = append(menu.Options, &objects.SelectOptions{
+			Label:   capitalize(p.label),
+			Value:   p.name,
+			Default: p.name == req.period.name,
+		})
+	}
+
+	return &objects.Component{
+		Type:       objects.ComponentTypeActionRow,
+		Components: []*objects.Component{menu},
+	}, nil
+}
+
+func NewStatsInteraction(listensRepo listens.Repository, catalogRepo catalog.Repository) *Interaction {
+	topArtists := func(ctx context.Context, req statsRequest, sb *strings.Builder) error {
+		artists, err := catalogRepo.GetTopArtists(ctx, req.discordID, req.period.since(time.Now()), req.count)
+		if err != nil {
+			return err
+		}
+		if len(artists) == 0 {
+			fmt.Fprintf(sb, "No listens found for %s in %s", req.mention, req.period.label)
+			return nil
+		}
+
+		fmt.Fprintf(sb, "Top artists for %s in %s:\n", req.mention, req.period.label)
+		for i, a := range artists {
+			fmt.Fprintf(sb, "%d. %s (%d plays)\n", i+1, a.Artist.Name, a.Plays)
+		}
+		return nil
+	}
+
+	topTracks := func(ctx context.Context, req statsRequest, sb *strings.Builder) error {
+		tracks, err := catalogRepo.GetTopTracks(ctx, req.discordID, req.period.since(time.Now()), req.count)
+		if err != nil {
+			return err
+		}
+		if len(tracks) == 0 {
+			fmt.Fprintf(sb, "No listens found for %s in %s", req.mention, req.period.label)
+			return nil
+		}
+
+		fmt.Fprintf(sb, "Top tracks for %s in %s:\n", req.mention, req.period.label)
+		for i, t := range tracks {
+			artistNames := []string{}
+			for _, a := range t.Track.Artists {
+				artistNames = append(artistNames, a.Name)
+			}
+			fmt.Fprintf(sb, "%d. %s - %s (%d plays)\n", i+1, t.Track.Name, strings.Join(artistNames, ", "), t.Plays)
+		}
+		return nil
+	}
+
+	listeningTime := func(ctx context.Context, req statsRequest, sb *strings.Builder) error {
+		since := req.period.since(time.Now())
+
+		total, err := listensRepo.GetUserListeningTime(ctx, req.discordID, since)
+		if err != nil {
+			return err
+		}
+
+		contexts, err := listensRepo.GetTopContexts(ctx, req.discordID, since, 3)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(sb, "Listening time for %s in %s: %s", req.mention, req.period.label, formatDuration(total))
+		if len(contexts) > 0 {
+			sb.WriteString("\nMostly from:\n")
+			for _, c := range contexts {
+				fmt.Fprintf(sb, "- %s %s (%s)\n", c.ContextType, c.ContextURI, formatDuration(c.ListeningTime))
+			}
+		}
+		return nil
+	}
+
+	artist := func(ctx context.Context, req statsRequest, sb *strings.Builder) error {
+		plays, err := catalogRepo.GetArtistPlays(ctx, req.discordID, req.artistID, req.period.since(time.Now()))
+		if err == catalog.ErrArtistNotFound {
+			sb.WriteString("Couldn't find that artist, try picking one of the suggestions")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(sb, "%s played %s %d times in %s", capitalize(req.mention), plays.Artist.Name, plays.Plays, req.period.label)
+		return nil
+	}
+
+	// Suggest the invoker's own most played artists, as they are the most
+	// likely to be looked up.
+	suggestArtists := func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		value string,
+	) ([]objects.ApplicationCommandOptionChoice, error) {
+		discordID := invokerOf(interaction).DiscordID()
+		artists, err := catalogRepo.SearchListenedArtists(ctx, discordID, value, maxAutocompleteChoices)
+		if err != nil {
+			return nil, err
+		}
+
+		choices := []objects.ApplicationCommandOptionChoice{}
+		for _, a := range artists {
+			choices = append(choices, objects.ApplicationCommandOptionChoice{
+				Name:  a.Artist.Name,
+				Value: a.Artist.ID,
+			})
+		}
+		return choices, nil
+	}
+
+	renderers := map[string]func(context.Context, statsRequest, *strings.Builder) error{
+		"top-artists":    topArtists,
+		"top-tracks":     topTracks,
+		"listening-time": listeningTime,
+		"artist":         artist,
+	}
+
+	render := func(ctx context.Context, owner string, req statsRequest) (*objects.InteractionApplicationCommandCallbackData, error) {
+		renderer, ok := renderers[req.subcommand]
+		if !ok {
+			return nil, fmt.Errorf("unknown stats subcommand: %s", req.subcommand)
+		}
+
+		sb := &strings.Builder{}
+		if err := renderer(ctx, req, sb); err != nil {
+			return nil, err
+		}
+
+		// The artist comes from the user, and may not fit in the menu's
+		// custom_id. The stats are still worth showing without it.
+		components := []*objects.Component{}
+		menu, err := req.periodMenu(owner)
+		switch {
+		case err == nil:
+			components = append(components, menu)
+		case !errors.Is(err, errCustomIDTooLong):
+			return nil, err
+		}
+
+		return &objects.InteractionApplicationCommandCallbackData{
+			Content: sb.String(),
+			// Don't ping users just because we are showing their stats.
+			AllowedMentions: noMentions,
+			Components:      components,
+		}, nil
+	}
+
+	subcommands := map[string]handler{}
+	for name := range renderers {
+		name := name
+		subcommands[name] = func(
+			ctx context.Context,
+			interaction *objects.Interaction,
+			interactionData *objects.ApplicationCommandInteractionData,
+		) (*objects.InteractionResponse, error) {
+			owner := invokerOf(interaction).DiscordID()
+			data, err := render(ctx, owner, parseStatsRequest(name, interaction, interactionData))
+			if err != nil {
+				return nil, err
+			}
+
+			return &objects.InteractionResponse{
+				Type: objects.ResponseChannelMessageWithSource,
+				Data: data,
+			}, nil
+		}
+	}
+
+	changePeriod := func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		componentData *objects.ApplicationComponentInteractionData,
+		state []string,
+	) (*objects.InteractionResponse, error) {
+		if len(state) != 4 || len(componentData.Values) != 1 {
+			return nil, fmt.Errorf("invalid stats period state: %v", state)
+		}
+
+		count, err := strconv.Atoi(state[2])
+		if err != nil {
+			return nil, err
+		}
+		p, ok := findPeriod(componentData.Values[0])
+		if !ok {
+			return nil, fmt.Errorf("unknown period: %s", componentData.Values[0])
+		}
+
+		// Only the owner can get here, so they stay the owner.
+		data, err := render(ctx, invokerOf(interaction).DiscordID(), statsRequest{
+			subcommand: state[0],
+			discordID:  state[1],
+			mention:    fmt.Sprintf("<@%s>", state[1]),
+			period:     p,
+			count:      count,
+			artistID:   state[3],
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &objects.InteractionResponse{
+			Type: objects.ResponseUpdateMessage,
+			Data: data,
+		}, nil
+	}
+
+	return &Interaction{
+		ApplicationCommand: &objects.ApplicationCommand{
+			Name:              "stats",
+			Description:       "Shows listening stats",
+			DefaultPermission: true,
+			Options: []objects.ApplicationCommandOption{
+				{
+					OptionType:  objects.TypeSubCommand,
+					Name:        "top-artists",
+					Description: "Shows the most played artists",
+					Options:     statsOptions,
+				},
+				{
+					OptionType:  objects.TypeSubCommand,
+					Name:        "top-tracks",
+					Description: "Shows the most played tracks",
+					Options:     statsOptions,
+				},
+				{
+					OptionType:  objects.TypeSubCommand,
+					Name:        "listening-time",
+					Description: "Shows how long has been spent listening",
+					Options: []objects.ApplicationCommandOption{
+						statsOptions[0],
+						statsOptions[2],
+					},
+				},
+				{
+					OptionType:  objects.TypeSubCommand,
+					Name:        "artist",
+					Description: "Shows how often an artist has been played",
+					Options: []objects.ApplicationCommandOption{
+						{
+							OptionType:  objects.TypeString,
+							Name:        "name",
+							Description: "The artist to look up",
+							Required:    true,
+						},
+						statsOptions[0],
+						statsOptions[2],
+					},
+				},
+			},
+		},
+		subcommands: subcommands,
+		dmCapable:   true,
+		autocomplete: map[string]autocompleteHandler{
+			"artist name": suggestArtists,
+		},
+		components: []*Component{
+			{
+				Name:    statsPeriodComponent,
+				handler: changePeriod,
+				owned:   true,
+			},
+		},
+	}
+}
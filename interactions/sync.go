@@ -0,0 +1,203 @@
+package interactions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/Postcord/objects"
+	"go.uber.org/zap"
+)
+
+// commandDefinition is the subset of a command we manage, used to compare
+// what we want registered with what Discord has.
+type commandDefinition struct {
+	Type              objects.ApplicationCommandType `json:"type"`
+	Name              string                         `json:"name"`
+	Description       string                         `json:"description"`
+	Options           []optionDefinition             `json:"options"`
+	DefaultPermission *bool                          `json:"default_permission"`
+	DMPermission      *bool                          `json:"dm_permission"`
+}
+
+type optionDefinition struct {
+	Type         objects.ApplicationCommandOptionType `json:"type"`
+	Name         string                               `json:"name"`
+	Description  string                               `json:"description"`
+	Required     bool                                 `json:"required"`
+	Autocomplete bool                                 `json:"autocomplete"`
+	Choices      []choiceDefinition                   `json:"choices"`
+	Options      []optionDefinition                   `json:"options"`
+}
+
+type choiceDefinition struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+func parseCommandDefinition(raw json.RawMessage) (*commandDefinition, error) {
+	def := &commandDefinition{}
+	if err := json.Unmarshal(raw, def); err != nil {
+		return nil, err
+	}
+
+	// Fill in what Discord defaults, so leaving a field out doesn't count
+	// as a change.
+	if def.Type == 0 {
+		def.Type = objects.CommandTypeChatInput
+	}
+	enabled := true
+	if def.DefaultPermission == nil {
+		def.DefaultPermission = &enabled
+	}
+	if def.DMPermission == nil {
+		def.DMPermission = &enabled
+	}
+	def.Options = normalizeOptions(def.Options)
+
+	return def, nil
+}
+
+func normalizeOptions(opts []optionDefinition) []optionDefinition {
+	if len(opts) == 0 {
+		return nil
+	}
+	for i := range opts {
+		if len(opts[i].Choices) == 0 {
+			opts[i].Choices = nil
+		}
+		opts[i].Options = normalizeOptions(opts[i].Options)
+	}
+	return opts
+}
+
+func (d *commandDefinition) key() string {
+	return routeKey(d.Type, d.Name)
+}
+
+// SyncPlan is what needs to change for Discord's commands to match ours. The
+// commands are named by routeKey.
+type SyncPlan struct {
+	Create    []string
+	Update    []string
+	Delete    []string
+	Unchanged []string
+}
+
+func (p *SyncPlan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Update) == 0 && len(p.Delete) == 0
+}
+
+// planSync compares the commands. Discord doesn't return dm_permission for
+// guild commands, so it's only compared for global ones.
+func planSync(desired, existing []json.RawMessage, global bool) (*SyncPlan, error) {
+	parse := func(raw json.RawMessage) (*commandDefinition, error) {
+		def, err := parseCommandDefinition(raw)
+		if err != nil {
+			return nil, err
+		}
+		if !global {
+			def.DMPermission = nil
+		}
+		return def, nil
+	}
+
+	existingDefs := map[string]*commandDefinition{}
+	for _, raw := range existing {
+		def, err := parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		existingDefs[def.key()] = def
+	}
+
+	plan := &SyncPlan{}
+	seen := map[string]bool{}
+	for _, raw := range desired {
+		def, err := parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		key := def.key()
+		if seen[key] {
+			return nil, fmt.Errorf("command %s is defined twice", key)
+		}
+		seen[key] = true
+
+		current, ok := existingDefs[key]
+		switch {
+		case !ok:
+			plan.Create = append(plan.Create, key)
+		case !reflect.DeepEqual(def, current):
+			plan.Update = append(plan.Update, key)
+		default:
+			plan.Unchanged = append(plan.Unchanged, key)
+		}
+	}
+
+	for key := range existingDefs {
+		if !seen[key] {
+			plan.Delete = append(plan.Delete, key)
+		}
+	}
+	sort.Strings(plan.Delete)
+
+	return plan, nil
+}
+
+// SyncCommands makes the commands registered with Discord match interactions,
+// globally or for a single guild. Discord is only called to make changes if
+// there are any, and never in a dry run.
+func SyncCommands(
+	ctx context.Context,
+	log *zap.Logger,
+	commands *CommandsClient,
+	guildID *objects.Snowflake,
+	interactions []*Interaction,
+	dryRun bool,
+) (*SyncPlan, error) {
+	appID, err := commands.ApplicationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := []json.RawMessage{}
+	for _, i := range interactions {
+		payload, err := commandPayload(i, guildID == nil)
+		if err != nil {
+			return nil, err
+		}
+		desired = append(desired, payload)
+	}
+
+	existing, err := commands.GetCommands(ctx, appID, guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := planSync(desired, existing, guildID == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("command sync plan",
+		zap.Strings("create", plan.Create),
+		zap.Strings("update", plan.Update),
+		zap.Strings("delete", plan.Delete),
+		zap.Int("unchanged", len(plan.Unchanged)),
+		zap.Bool("dry_run", dryRun),
+	)
+	if dryRun || plan.Empty() {
+		return plan, nil
+	}
+
+	// Overwriting everything at once means we only make a single request no
+	// matter how many commands there are.
+	if err := commands.BulkOverwriteCommands(ctx, appID, guildID, desired); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,107 @@
+package interactions
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Postcord/objects"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestPlanSync(t *testing.T) {
+	desired := []json.RawMessage{
+		json.RawMessage(`{"name": "np", "description": "Now playing", "options": null, "default_permission": true}`),
+		json.RawMessage(`{"name": "stats", "description": "Stats", "options": [{"type": 1, "name": "top", "description": "Top", "default": false}]}`),
+		json.RawMessage(`{"name": "Compare taste", "type": 2, "options": null}`),
+	}
+	existing := []json.RawMessage{
+		json.RawMessage(`{"id": "1", "application_id": "2", "version": "3", "type": 1, "name": "np", "description": "Now playing", "default_permission": true}`),
+		json.RawMessage(`{"id": "4", "type": 1, "name": "stats", "description": "Old stats", "options": [{"type": 1, "name": "top", "description": "Top"}]}`),
+		json.RawMessage(`{"id": "5", "type": 1, "name": "leaderboard", "description": "Leaderboard"}`),
+	}
+
+	plan, err := planSync(desired, existing, true)
+	require.NoError(t, err)
+	assert.Equal(t, &SyncPlan{
+		Create:    []string{"2:Compare taste"},
+		Update:    []string{"stats"},
+		Delete:    []string{"leaderboard"},
+		Unchanged: []string{"np"},
+	}, plan)
+
+	_, err = planSync(append(desired, desired[0]), nil, true)
+	assert.EqualError(t, err, "command np is defined twice")
+}
+
+func TestPlanSyncDMPermission(t *testing.T) {
+	desired := []json.RawMessage{
+		json.RawMessage(`{"name": "np", "description": "Now playing", "dm_permission": false}`),
+	}
+	// Discord leaves dm_permission out of guild commands.
+	existing := []json.RawMessage{
+		json.RawMessage(`{"id": "1", "type": 1, "name": "np", "description": "Now playing"}`),
+	}
+
+	plan, err := planSync(desired, existing, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"np"}, plan.Update)
+
+	plan, err = planSync(desired, existing, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"np"}, plan.Unchanged)
+}
+
+func TestSyncCommands(t *testing.T) {
+	registered := `[{"id": "1", "type": 1, "name": "old", "description": "Old"}]`
+	overwrites := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bot token", r.Header.Get("Authorization"))
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/oauth2/applications/@me":
+			_, _ = w.Write([]byte(`{"id": "10"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/applications/10/guilds/20/commands":
+			_, _ = w.Write([]byte(registered))
+		case r.Method == http.MethodPut && r.URL.Path == "/applications/10/guilds/20/commands":
+			overwrites++
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			assert.NotContains(t, string(body), "dm_permission")
+			registered = string(body)
+			_, _ = w.Write(body)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	client := NewCommandsClient("Bot token")
+	client.BaseURL = srv.URL
+	guildID := objects.Snowflake(20)
+	cmds := []*Interaction{
+		{ApplicationCommand: &objects.ApplicationCommand{Name: "new", Description: "New", DefaultPermission: true}},
+	}
+
+	plan, err := SyncCommands(context.Background(), zaptest.NewLogger(t), client, &guildID, cmds, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new"}, plan.Create)
+	assert.Equal(t, []string{"old"}, plan.Delete)
+	assert.Equal(t, 0, overwrites, "dry run should not change anything")
+
+	_, err = SyncCommands(context.Background(), zaptest.NewLogger(t), client, &guildID, cmds, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, overwrites)
+
+	plan, err = SyncCommands(context.Background(), zaptest.NewLogger(t), client, &guildID, cmds, false)
+	require.NoError(t, err)
+	assert.True(t, plan.Empty())
+	assert.Equal(t, 1, overwrites, "nothing should be overwritten once in sync")
+}
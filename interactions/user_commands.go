@@ -0,0 +1,171 @@
+package interactions
+
+import (
+	"context"
+	"fmt"
+	"oscen/repositories/users"
+	"strings"
+
+	"github.com/Postcord/objects"
+	"github.com/zmb3/spotify/v2"
+)
+
+// NewListeningToInteraction is a user command showing what the user it's used
+// on is currently listening to.
+func NewListeningToInteraction(userRepo users.Repository, auth spotifyAuth) *Interaction {
+	h := func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		interactionData *objects.ApplicationCommandInteractionData,
+	) (*objects.InteractionResponse, error) {
+		target, member, err := targetUser(interactionData)
+		if err != nil {
+			return nil, err
+		}
+		name := displayName(target, member)
+		targetID := fmt.Sprintf("%d", target.ID)
+
+		client, err := ensureSpotifyClient(ctx, targetID, userRepo, auth)
+		if err != nil {
+			return targetLinkErrorResponse(ctx, targetID, name, userRepo, err)
+		}
+
+		np, err := client.PlayerCurrentlyPlaying(ctx)
+		if err != nil {
+			return targetLinkErrorResponse(ctx, targetID, name, userRepo, err)
+		}
+
+		content := fmt.Sprintf("%s isn't listening to anything...", name)
+		if np != nil && np.Item != nil {
+			artistNames := []string{}
+			for _, a := range np.Item.Artists {
+				artistNames = append(artistNames, a.Name)
+			}
+			content = fmt.Sprintf("%s is listening to %s - %s", name, np.Item.Name, strings.Join(artistNames, ", "))
+		}
+
+		return &objects.InteractionResponse{
+			Type: objects.ResponseChannelMessageWithSource,
+			Data: &objects.InteractionApplicationCommandCallbackData{
+				Content:         content,
+				AllowedMentions: noMentions,
+			},
+		}, nil
+	}
+
+	return &Interaction{
+		ApplicationCommand: &objects.ApplicationCommand{
+			Name:              "What are they listening to?",
+			Type:              commandType(objects.CommandTypeUser),
+			DefaultPermission: true,
+		},
+		handler:   h,
+		dmCapable: true,
+	}
+}
+
+func topArtistNames(ctx context.Context, client *spotify.Client) ([]string, error) {
+	page, err := client.CurrentUsersTopArtists(
+		ctx,
+		spotify.Limit(50),
+		spotify.Timerange(spotify.MediumTermRange),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, a := range page.Artists {
+		names = append(names, a.Name)
+	}
+	return names, nil
+}
+
+// tasteOverlap returns the artists in both lists, in the order of the first,
+// and how similar the lists are as a percentage.
+func tasteOverlap(a, b []string) ([]string, int) {
+	inB := map[string]bool{}
+	for _, name := range b {
+		inB[name] = true
+	}
+
+	shared := []string{}
+	for _, name := range a {
+		if inB[name] {
+			shared = append(shared, name)
+		}
+	}
+
+	union := len(a) + len(b) - len(shared)
+	if union == 0 {
+		return shared, 0
+	}
+	return shared, len(shared) * 100 / union
+}
+
+// NewCompareTasteInteraction is a user command comparing the top artists of
+// the invoker and the user it's used on.
+func NewCompareTasteInteraction(userRepo users.Repository, auth spotifyAuth) *Interaction {
+	h := func(
+		ctx context.Context,
+		interaction *objects.Interaction,
+		interactionData *objects.ApplicationCommandInteractionData,
+	) (*objects.InteractionResponse, error) {
+		target, member, err := targetUser(interactionData)
+		if err != nil {
+			return nil, err
+		}
+		inv := invokerOf(interaction)
+		if target.ID == inv.ID {
+			return ephemeralResponse("You have exactly the same taste as yourself"), nil
+		}
+		name := displayName(target, member)
+		targetID := fmt.Sprintf("%d", target.ID)
+
+		targetClient, err := ensureSpotifyClient(ctx, targetID, userRepo, auth)
+		if err != nil {
+			return targetLinkErrorResponse(ctx, targetID, name, userRepo, err)
+		}
+
+		artists, err := topArtistNames(ctx, spotifyClientFrom(ctx))
+		if err != nil {
+			return nil, err
+		}
+		targetArtists, err := topArtistNames(ctx, targetClient)
+		if err != nil {
+			return targetLinkErrorResponse(ctx, targetID, name, userRepo, err)
+		}
+
+		shared, score := tasteOverlap(artists, targetArtists)
+
+		content := fmt.Sprintf("<@%d> and %s have no top artists in common", inv.ID, name)
+		if len(shared) > 0 {
+			if len(shared) > 5 {
+				shared = shared[:5]
+			}
+			content = fmt.Sprintf(
+				"<@%d> and %s are a %d%% match, both listening to %s",
+				inv.ID, name, score, strings.Join(shared, ", "),
+			)
+		}
+
+		return &objects.InteractionResponse{
+			Type: objects.ResponseChannelMessageWithSource,
+			Data: &objects.InteractionApplicationCommandCallbackData{
+				Content:         content,
+				AllowedMentions: noMentions,
+			},
+		}, nil
+	}
+
+	return &Interaction{
+		ApplicationCommand: &objects.ApplicationCommand{
+			Name:              "Compare taste",
+			Type:              commandType(objects.CommandTypeUser),
+			DefaultPermission: true,
+		},
+		handler:    h,
+		dmCapable:  true,
+		middleware: []Middleware{requireSpotifyLink(userRepo, auth)},
+	}
+}
@@ -0,0 +1,104 @@
+package leader
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRetryInterval     = 15 * time.Second
+	defaultHeartbeatInterval = 5 * time.Second
+)
+
+// Elector runs a function on exactly one replica at a time, whichever holds
+// the lock.
+type Elector struct {
+	Locker Locker
+	Log    *zap.Logger
+	// LockID identifies the lease. Every replica competing for the same
+	// work must use the same ID.
+	LockID int64
+	// RetryInterval is how often a follower tries to become the leader.
+	RetryInterval time.Duration
+	// HeartbeatInterval is how often the leader checks it still holds its
+	// connection, and so the lock.
+	HeartbeatInterval time.Duration
+}
+
+// Run blocks until ctx is cancelled, calling fn whenever this replica becomes
+// the leader. The context passed to fn is cancelled if leadership is lost.
+func (e *Elector) Run(ctx context.Context, fn func(ctx context.Context)) {
+	retry := e.RetryInterval
+	if retry == 0 {
+		retry = defaultRetryInterval
+	}
+
+	for {
+		led, err := e.lead(ctx, fn)
+		if err != nil && ctx.Err() == nil {
+			e.Log.Error("leader election failed", zap.Error(err))
+		}
+		if led {
+			e.Log.Info("stepped down as leader")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+	}
+}
+
+// lead attempts to take the lock, and runs fn for as long as it is held. It
+// reports whether this replica was the leader.
+func (e *Elector) lead(ctx context.Context, fn func(ctx context.Context)) (bool, error) {
+	lease, err := e.Locker.TryLock(ctx, e.LockID)
+	if err != nil {
+		return false, err
+	}
+	if lease == nil {
+		e.Log.Debug("another replica is the leader")
+		return false, nil
+	}
+	e.Log.Info("became leader", zap.Int64("lock_id", e.LockID))
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(leaderCtx)
+	}()
+
+	heartbeat := e.HeartbeatInterval
+	if heartbeat == 0 {
+		heartbeat = defaultHeartbeatInterval
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pingCtx, pingCancel := context.WithTimeout(ctx, heartbeat)
+			err := lease.Heartbeat(pingCtx)
+			pingCancel()
+			if err != nil {
+				// We can't tell whether we still hold the lock. Stop work
+				// before letting it go, so two leaders never overlap.
+				cancel()
+				<-done
+				lease.Abandon()
+				return true, err
+			}
+		case <-done:
+			return true, lease.Release()
+		case <-ctx.Done():
+			<-done
+			return true, lease.Release()
+		}
+	}
+}
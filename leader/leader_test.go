@@ -0,0 +1,204 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+)
+
+// fakeLocker is a single lock shared by every elector using it, like an
+// advisory lock shared by replicas.
+type fakeLocker struct {
+	mu     sync.Mutex
+	holder *fakeLease
+	leases []*fakeLease
+}
+
+func (l *fakeLocker) TryLock(_ context.Context, _ int64) (Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder != nil {
+		return nil, nil
+	}
+	l.holder = &fakeLease{locker: l}
+	l.leases = append(l.leases, l.holder)
+	return l.holder, nil
+}
+
+func (l *fakeLocker) lease(i int) *fakeLease {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leases[i]
+}
+
+type fakeLease struct {
+	locker *fakeLocker
+
+	// Guarded by locker.mu.
+	heartbeatErr error
+	released     bool
+	abandoned    bool
+}
+
+func (l *fakeLease) Heartbeat(context.Context) error {
+	l.locker.mu.Lock()
+	defer l.locker.mu.Unlock()
+	return l.heartbeatErr
+}
+
+func (l *fakeLease) drop() {
+	if l.locker.holder == l {
+		l.locker.holder = nil
+	}
+}
+
+func (l *fakeLease) Release() error {
+	l.locker.mu.Lock()
+	defer l.locker.mu.Unlock()
+	l.released = true
+	l.drop()
+	return nil
+}
+
+func (l *fakeLease) Abandon() {
+	l.locker.mu.Lock()
+	defer l.locker.mu.Unlock()
+	l.abandoned = true
+	l.drop()
+}
+
+func (l *fakeLease) state() (released, abandoned bool) {
+	l.locker.mu.Lock()
+	defer l.locker.mu.Unlock()
+	return l.released, l.abandoned
+}
+
+func newElector(t *testing.T, locker Locker) *Elector {
+	return &Elector{
+		Locker:            locker,
+		Log:               zaptest.NewLogger(t),
+		LockID:            1,
+		RetryInterval:     5 * time.Millisecond,
+		HeartbeatInterval: 5 * time.Millisecond,
+	}
+}
+
+// runElector runs e in the background, sending on the returned channel each
+// time it becomes leader. fn blocks until leadership is lost.
+func runElector(ctx context.Context, e *Elector) (led <-chan context.Context, stopped <-chan struct{}) {
+	ledCh := make(chan context.Context, 10)
+	stoppedCh := make(chan struct{})
+	go func() {
+		defer close(stoppedCh)
+		e.Run(ctx, func(ctx context.Context) {
+			ledCh <- ctx
+			<-ctx.Done()
+		})
+	}()
+	return ledCh, stoppedCh
+}
+
+// waitLed returns the context passed to fn the next time the elector leads.
+func waitLed(t *testing.T, led <-chan context.Context) context.Context {
+	t.Helper()
+	select {
+	case ctx := <-led:
+		return ctx
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting to lead")
+		return nil
+	}
+}
+
+func waitStopped(t *testing.T, stopped <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Run to return")
+	}
+}
+
+func TestElectorAcquiresAndReleasesOnCancel(t *testing.T) {
+	locker := &fakeLocker{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	led, stopped := runElector(ctx, newElector(t, locker))
+
+	leaderCtx := waitLed(t, led)
+
+	cancel()
+	waitStopped(t, stopped)
+	assert.Error(t, leaderCtx.Err())
+
+	released, abandoned := locker.lease(0).state()
+	assert.True(t, released)
+	assert.False(t, abandoned)
+}
+
+func TestElectorHandsOver(t *testing.T) {
+	locker := &fakeLocker{}
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	defer stopFirst()
+	firstLed, firstStopped := runElector(firstCtx, newElector(t, locker))
+	waitLed(t, firstLed)
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	secondLed, secondStopped := runElector(secondCtx, newElector(t, locker))
+
+	// The second replica keeps retrying, but can't lead while the first
+	// holds the lock.
+	select {
+	case <-secondLed:
+		t.Fatal("second replica led while the first held the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	stopFirst()
+	waitStopped(t, firstStopped)
+	waitLed(t, secondLed)
+
+	stopSecond()
+	waitStopped(t, secondStopped)
+}
+
+func TestElectorStepsDownWhenHeartbeatFails(t *testing.T) {
+	locker := &fakeLocker{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	led, stopped := runElector(ctx, newElector(t, locker))
+
+	leaderCtx := waitLed(t, led)
+
+	locker.mu.Lock()
+	locker.leases[0].heartbeatErr = errors.New("connection lost")
+	locker.mu.Unlock()
+
+	// Work stops once the heartbeat fails, and the lock is abandoned rather
+	// than released, as we can't tell if we still hold it.
+	select {
+	case <-leaderCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("work wasn't stopped after the heartbeat failed")
+	}
+	waitLed(t, led)
+
+	released, abandoned := locker.lease(0).state()
+	assert.False(t, released)
+	assert.True(t, abandoned)
+
+	cancel()
+	waitStopped(t, stopped)
+	// It took the lock again once it was free.
+	assert.NotNil(t, locker.lease(1))
+}
@@ -0,0 +1,91 @@
+package leader
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Locker hands out the lock replicas compete for.
+type Locker interface {
+	// TryLock takes the lock if nobody else holds it. It returns a nil Lease
+	// if somebody does.
+	TryLock(ctx context.Context, lockID int64) (Lease, error)
+}
+
+// Lease is a held lock.
+type Lease interface {
+	// Heartbeat checks the lock is still held.
+	Heartbeat(ctx context.Context) error
+	// Release gives the lock up.
+	Release() error
+	// Abandon drops the lock after a failed heartbeat, when it can't be
+	// released cleanly.
+	Abandon()
+}
+
+// PostgresLocker uses session level Postgres advisory locks, so if the leader
+// dies its connection drops, the lock is released and another replica takes
+// over.
+type PostgresLocker struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresLocker(db *pgxpool.Pool) *PostgresLocker {
+	return &PostgresLocker{db: db}
+}
+
+func (l *PostgresLocker) TryLock(ctx context.Context, lockID int64) (Lease, error) {
+	conn, err := l.db.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	acquired := false
+	//language=SQL
+	sql := "SELECT pg_try_advisory_lock($1);"
+	if err := conn.QueryRow(ctx, sql, lockID).Scan(&acquired); err != nil {
+		conn.Release()
+		return nil, err
+	}
+	if !acquired {
+		conn.Release()
+		return nil, nil
+	}
+
+	return &postgresLease{conn: conn, lockID: lockID}, nil
+}
+
+// postgresLease holds on to the connection that took the lock, as advisory
+// locks belong to the session.
+type postgresLease struct {
+	conn   *pgxpool.Conn
+	lockID int64
+}
+
+func (l *postgresLease) Heartbeat(ctx context.Context) error {
+	return l.conn.Ping(ctx)
+}
+
+func (l *postgresLease) Release() error {
+	defer l.conn.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	//language=SQL
+	sql := "SELECT pg_advisory_unlock($1);"
+	if _, err := l.conn.Exec(ctx, sql, l.lockID); err != nil {
+		_ = l.conn.Conn().Close(ctx)
+		return err
+	}
+	return nil
+}
+
+// Abandon closes the connection, so it can't make its way back into the pool
+// still holding the lock.
+func (l *postgresLease) Abandon() {
+	_ = l.conn.Conn().Close(context.Background())
+	l.conn.Release()
+}
@@ -0,0 +1,44 @@
+package leader
+
+import (
+	"context"
+	"oscen/repositories/repotest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresLocker(t *testing.T) {
+	ctx := context.Background()
+	db := repotest.Postgres(t)
+	// Each lease holds its own connection, so these compete like replicas.
+	first, second := NewPostgresLocker(db), NewPostgresLocker(db)
+
+	lease, err := first.TryLock(ctx, 42)
+	require.NoError(t, err)
+	require.NotNil(t, lease)
+	assert.NoError(t, lease.Heartbeat(ctx))
+
+	held, err := second.TryLock(ctx, 42)
+	require.NoError(t, err)
+	assert.Nil(t, held)
+
+	// Other locks are independent.
+	other, err := second.TryLock(ctx, 43)
+	require.NoError(t, err)
+	require.NotNil(t, other)
+	require.NoError(t, other.Release())
+
+	require.NoError(t, lease.Release())
+	lease, err = second.TryLock(ctx, 42)
+	require.NoError(t, err)
+	require.NotNil(t, lease)
+
+	// Abandoning closes the session, which frees the lock too.
+	lease.Abandon()
+	lease, err = first.TryLock(ctx, 42)
+	require.NoError(t, err)
+	require.NotNil(t, lease)
+	require.NoError(t, lease.Release())
+}
@@ -0,0 +1,3 @@
+ALTER TABLE spotify_discord_links
+DROP COLUMN status,
+DROP COLUMN status_updated_at;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE spotify_discord_links
+ADD COLUMN status TEXT NOT NULL DEFAULT 'active',
+ADD COLUMN status_updated_at TIMESTAMP WITH TIME ZONE;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE spotify_discord_links
+DROP COLUMN scrape_failures,
+DROP COLUMN next_scrape_at,
+DROP COLUMN last_scrape_error;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE spotify_discord_links
+ADD COLUMN scrape_failures INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN next_scrape_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN last_scrape_error TEXT;
\ No newline at end of file
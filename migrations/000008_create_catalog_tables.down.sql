@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS track_artists;
+DROP TABLE IF EXISTS tracks;
+DROP TABLE IF EXISTS albums;
+DROP TABLE IF EXISTS artists;
\ No newline at end of file
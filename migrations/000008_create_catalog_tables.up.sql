@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS artists(
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS albums(
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    album_type TEXT NOT NULL,
+    release_date TEXT NOT NULL,
+    image_url TEXT
+);
+
+CREATE TABLE IF NOT EXISTS tracks(
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    album_id TEXT REFERENCES albums(id),
+    duration_ms INTEGER NOT NULL,
+    explicit BOOLEAN NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS track_artists(
+    track_id TEXT REFERENCES tracks(id),
+    artist_id TEXT REFERENCES artists(id),
+    position INTEGER NOT NULL,
+    PRIMARY KEY (track_id, artist_id)
+);
+
+CREATE INDEX IF NOT EXISTS track_artists_artist_id_idx ON track_artists(artist_id);
\ No newline at end of file
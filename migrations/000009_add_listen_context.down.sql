@@ -0,0 +1,4 @@
+ALTER TABLE listens
+DROP COLUMN context_type,
+DROP COLUMN context_uri,
+DROP COLUMN duration_ms;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE listens
+ADD COLUMN context_type TEXT,
+ADD COLUMN context_uri TEXT,
+ADD COLUMN duration_ms INTEGER;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS seen_interactions;
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS seen_interactions(
+    id TEXT PRIMARY KEY,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS seen_interactions_expires_at_idx ON seen_interactions(expires_at);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS guild_members;
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS guild_members(
+    guild_id TEXT NOT NULL,
+    discord_id TEXT NOT NULL,
+    seen_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (guild_id, discord_id)
+);
\ No newline at end of file
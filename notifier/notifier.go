@@ -0,0 +1,53 @@
+package notifier
+
+import (
+	"context"
+	"oscen/tracer"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Postcord/objects"
+	"github.com/Postcord/rest"
+)
+
+// Notifier sends direct messages to users about the state of their account.
+type Notifier struct {
+	Discord *rest.Client
+}
+
+func New(discord *rest.Client) *Notifier {
+	return &Notifier{Discord: discord}
+}
+
+func (n *Notifier) LinkRevoked(ctx context.Context, discordID string) error {
+	_, childSpan := tracer.Start(
+		ctx,
+		"notifier.link_revoked",
+		trace.WithAttributes(attribute.String("io.oscen.discord_user", discordID)),
+	)
+	defer childSpan.End()
+
+	return n.sendDM(discordID, "Oscen can no longer access your Spotify account, "+
+		"so we've stopped tracking your listens. Use /register to link it again.")
+}
+
+func (n *Notifier) sendDM(discordID string, content string) error {
+	id, err := strconv.ParseUint(discordID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	channel, err := n.Discord.CreateDM(&rest.CreateDMParams{
+		RecipientID: objects.Snowflake(id),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = n.Discord.CreateMessage(channel.ID, &rest.CreateMessageParams{
+		Content: content,
+	})
+	return err
+}
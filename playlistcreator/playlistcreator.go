@@ -2,84 +2,144 @@ package playlistcreator
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"oscen/repositories/guildmembers"
 	"oscen/repositories/users"
 	"oscen/tracer"
 	"time"
 
-	spotifyauth "github.com/zmb3/spotify/v2/auth"
-
 	"github.com/Postcord/objects"
-	"github.com/Postcord/rest"
 	"github.com/zmb3/spotify/v2"
 	"go.uber.org/zap"
 )
 
+// discordClient is the part of *rest.Client used to name playlists after
+// the guild.
+type discordClient interface {
+	GetGuild(id objects.Snowflake) (*objects.Guild, error)
+}
+
 type PlaylistCreator struct {
 	Logger      *zap.Logger
-	Discord     *rest.Client
-	UsersRepo   *users.PostgresRepository
-	SpotifyAuth *spotifyauth.Authenticator
+	Discord     discordClient
+	UsersRepo   users.Repository
+	MembersRepo guildmembers.Repository
+	SpotifyAuth users.SpotifyAuth
 }
 
 func New(
-	auth *spotifyauth.Authenticator,
-	discord *rest.Client,
-	usersRepo *users.PostgresRepository,
+	auth users.SpotifyAuth,
+	discord discordClient,
+	usersRepo users.Repository,
+	membersRepo guildmembers.Repository,
 	logger *zap.Logger,
 ) *PlaylistCreator {
 	return &PlaylistCreator{
 		SpotifyAuth: auth,
 		Discord:     discord,
 		UsersRepo:   usersRepo,
+		MembersRepo: membersRepo,
 		Logger:      logger,
 	}
 }
 
+// ErrPrivateNotAllowed is returned when asked for a private playlist by
+// someone who linked their account before Oscen could make them.
+var ErrPrivateNotAllowed = errors.New("not allowed to create private playlists")
+
+// ErrMembersNotSynced is returned when Oscen doesn't know anyone in the guild
+// yet, which happens until its members have been synced.
+var ErrMembersNotSynced = errors.New("guild members haven't been synced")
+
+// MaxSize is the most tracks we can put in a playlist, as we add them in a
+// single request.
+const MaxSize = 99
+
+// Options customise the generated playlist. Zero values use the defaults.
+type Options struct {
+	// Name defaults to one based on the guild name.
+	Name string
+	// Size is the number of tracks, up to MaxSize. Defaults to five per
+	// registered member.
+	Size int
+	// TimeRange is the period members' top tracks are taken from. Defaults
+	// to spotify.ShortTermRange.
+	TimeRange spotify.Range
+	Private   bool
+}
+
 func (pc *PlaylistCreator) Create(ctx context.Context, interaction *objects.Interaction, initiatorSpotify *spotify.Client) (*string, error) {
+	return pc.CreateWithOptions(ctx, interaction, initiatorSpotify, Options{})
+}
+
+func (pc *PlaylistCreator) CreateWithOptions(
+	ctx context.Context,
+	interaction *objects.Interaction,
+	initiatorSpotify *spotify.Client,
+	opts Options,
+) (*string, error) {
 	ctx, childSpan := tracer.Start(ctx, "playlist_creator.create")
 	defer childSpan.End()
 
-	const songsPerMember = 5
+	if opts.TimeRange == "" {
+		opts.TimeRange = spotify.ShortTermRange
+	}
 
-	members, err := pc.Discord.ListGuildMembers(interaction.GuildID, &rest.ListGuildMembersParams{
-		Limit: 1000,
-	})
-	// TODO: Pagination
+	memberIDs, err := pc.MembersRepo.GetMemberIDs(ctx, fmt.Sprintf("%d", interaction.GuildID))
 	if err != nil {
 		return nil, err
 	}
+	if len(memberIDs) == 0 {
+		return nil, ErrMembersNotSynced
+	}
 
 	// Filter down to guild members registered on our platform
+	members, err := pc.UsersRepo.GetUsersByDiscordIDs(ctx, memberIDs)
+	if err != nil {
+		return nil, err
+	}
 	registeredGuildMembers := []users.User{}
 	for _, member := range members {
-		discordId := fmt.Sprintf("%d", member.User.ID)
-		member, err := pc.UsersRepo.GetUserByDiscordID(ctx, discordId)
-		if err != nil {
-			if err == users.ErrUserNotRegistered {
-				continue
-			}
-			return nil, err
+		if member.Status == users.LinkStatusRevoked {
+			continue
 		}
+		registeredGuildMembers = append(registeredGuildMembers, member)
+	}
 
-		registeredGuildMembers = append(registeredGuildMembers, *member)
+	songsPerMember := 5
+	if opts.Size > 0 && len(registeredGuildMembers) > 0 {
+		// Round up so there are enough once duplicates are removed, we trim
+		// to size afterwards.
+		songsPerMember = (opts.Size + len(registeredGuildMembers) - 1) / len(registeredGuildMembers)
+		// Spotify won't return more top tracks than this in one go.
+		if songsPerMember > 50 {
+			songsPerMember = 50
+		}
 	}
 
 	// Get top songs per user
 	playlistSongs := []spotify.ID{}
 	for _, member := range registeredGuildMembers {
-		memberSpotify := member.SpotifyClient(ctx, pc.SpotifyAuth)
+		memberSpotify := member.SpotifyClient(ctx, pc.SpotifyAuth, pc.UsersRepo)
 		topTracks, err := memberSpotify.CurrentUsersTopTracks(
 			ctx,
 			spotify.Limit(songsPerMember),
-			spotify.Timerange(spotify.ShortTermRange),
+			spotify.Timerange(opts.TimeRange),
 		)
 		if err != nil {
 			pc.Logger.Warn("failed to fetch top tracks for user",
 				zap.Error(err),
 				zap.String("user_id", member.DiscordID),
 			)
+			if users.IsRevokedGrant(err) {
+				_, err := pc.UsersRepo.SetLinkStatus(ctx, member.DiscordID, users.LinkStatusRevoked)
+				if err != nil {
+					return nil, err
+				}
+			}
 			continue
 		}
 
@@ -94,10 +154,12 @@ func (pc *PlaylistCreator) Create(ctx context.Context, interaction *objects.Inte
 
 	playlistSongs = deduplicateTracks(playlistSongs)
 	shuffleTracks(playlistSongs)
+	if opts.Size > 0 && len(playlistSongs) > opts.Size {
+		playlistSongs = playlistSongs[:opts.Size]
+	}
 
-	// TODO: Pagination when more than 100
-	if len(playlistSongs) >= 100 {
-		return nil, fmt.Errorf("more than 100 songs not supported")
+	if len(playlistSongs) > MaxSize {
+		return nil, fmt.Errorf("more than %d songs not supported", MaxSize)
 	}
 
 	initiator, err := initiatorSpotify.CurrentUser(ctx)
@@ -110,18 +172,27 @@ func (pc *PlaylistCreator) Create(ctx context.Context, interaction *objects.Inte
 		return nil, err
 	}
 
+	name := opts.Name
+	if name == "" {
+		name = fmt.Sprintf("Guild Playlist - %s", guild.Name)
+	}
+
 	createdPlaylist, err := initiatorSpotify.CreatePlaylistForUser(
 		ctx,
 		initiator.ID,
-		fmt.Sprintf("Guild Playlist - %s", guild.Name),
+		name,
 		fmt.Sprintf(
 			"Guild playlist generated at %s by %s",
 			time.Now().String(),
-			interaction.Member.User.Username,
+			initiatorName(interaction),
 		),
-		true,
+		!opts.Private,
 		false,
 	)
+	var spotifyErr spotify.Error
+	if opts.Private && errors.As(err, &spotifyErr) && spotifyErr.Status == http.StatusForbidden {
+		return nil, ErrPrivateNotAllowed
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -142,6 +213,18 @@ func (pc *PlaylistCreator) Create(ctx context.Context, interaction *objects.Inte
 	return &spotifyURL, nil
 }
 
+// initiatorName is the username of whoever asked for the playlist. Member is
+// only set in guilds, so fall back to User rather than panicking.
+func initiatorName(interaction *objects.Interaction) string {
+	if interaction.Member != nil && interaction.Member.User != nil {
+		return interaction.Member.User.Username
+	}
+	if interaction.User != nil {
+		return interaction.User.Username
+	}
+	return "unknown"
+}
+
 func deduplicateTracks(tracks []spotify.ID) []spotify.ID {
 	keys := make(map[spotify.ID]bool)
 	deduplicatedList := []spotify.ID{}
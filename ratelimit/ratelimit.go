@@ -0,0 +1,199 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultRetryAfter = 5 * time.Second
+	defaultMaxRetries = 3
+)
+
+// Limiter is a token bucket shared by every client talking to an API on
+// behalf of a single app. When the API responds with a 429, the whole bucket
+// is paused for the Retry-After the API asked for, rather than just the
+// request that hit it.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu           sync.Mutex
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// NewLimiter creates a limiter allowing rate requests per second, with bursts
+// of up to burst requests.
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// BlockedUntil returns the time the limiter is paused until, following a 429.
+func (l *Limiter) BlockedUntil() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.blockedUntil
+}
+
+// reserve takes a token if one is available. Otherwise, it returns how long to
+// wait before trying again.
+func (l *Limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if now.Before(l.blockedUntil) {
+		return l.blockedUntil.Sub(now)
+	}
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// wait blocks until a request may be made, returning how long it waited.
+func (l *Limiter) wait(ctx context.Context) (time.Duration, error) {
+	waited := time.Duration(0)
+	for {
+		delay := l.reserve(time.Now())
+		if delay == 0 {
+			return waited, nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return waited, ctx.Err()
+		case <-timer.C:
+			waited += delay
+		}
+	}
+}
+
+func (l *Limiter) block(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+}
+
+// Transport wraps base so requests made through it are limited by l.
+func (l *Limiter) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{limiter: l, base: base, maxRetries: defaultMaxRetries}
+}
+
+type transport struct {
+	limiter    *Limiter
+	base       http.RoundTripper
+	maxRetries int
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	span := trace.SpanFromContext(ctx)
+
+	for attempt := 0; ; attempt++ {
+		waited, err := t.limiter.wait(ctx)
+		if waited > 0 {
+			span.SetAttributes(
+				attribute.Bool("io.oscen.ratelimit.throttled", true),
+				attribute.Int64("io.oscen.ratelimit.waited_ms", waited.Milliseconds()),
+			)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq, err = rewind(req)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+			return resp, err
+		}
+
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		t.limiter.block(retryAfter)
+		span.AddEvent("rate limited", trace.WithAttributes(
+			attribute.Int("io.oscen.ratelimit.attempt", attempt),
+			attribute.Int64("io.oscen.ratelimit.retry_after_ms", retryAfter.Milliseconds()),
+		))
+
+		// Requests with a body we can't replay are handed back to the caller
+		// as is, they'll see the 429.
+		if attempt >= t.maxRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+}
+
+// rewind clones req with a fresh copy of its body so it can be sent again.
+func rewind(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
+
+func parseRetryAfter(raw string) time.Duration {
+	if raw == "" {
+		return defaultRetryAfter
+	}
+
+	if seconds, err := strconv.Atoi(raw); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	// A date that has already passed, say from clock skew, means retry now.
+	if at, err := http.ParseTime(raw); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+		return 0
+	}
+
+	return defaultRetryAfter
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportRetriesAfter429(t *testing.T) {
+	calls := int32(0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	l := NewLimiter(100, 10)
+	client := &http.Client{Transport: l.Transport(nil)}
+
+	start := time.Now()
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("body"))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+}
+
+func TestLimiterTokenBucket(t *testing.T) {
+	l := NewLimiter(10, 2)
+	now := time.Now()
+
+	assert.Zero(t, l.reserve(now))
+	assert.Zero(t, l.reserve(now))
+	assert.InDelta(t, 100*time.Millisecond, l.reserve(now), float64(time.Millisecond))
+
+	// After 100ms another token is available.
+	assert.Zero(t, l.reserve(now.Add(100*time.Millisecond)))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3"))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter(""))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter("soon"))
+	assert.Zero(t, parseRetryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)))
+
+	d := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.True(t, d > 59*time.Minute && d <= time.Hour, "got %s", d)
+}
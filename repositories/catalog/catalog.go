@@ -0,0 +1,465 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"oscen/tracer"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type PostgresRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+type Artist struct {
+	ID   string
+	Name string
+}
+
+type Album struct {
+	ID          string
+	Name        string
+	AlbumType   string
+	ReleaseDate string
+	ImageURL    string
+}
+
+type Track struct {
+	ID         string
+	Name       string
+	DurationMs int
+	Explicit   bool
+	// Album may be nil if we've never been told which album the track is
+	// on.
+	Album *Album
+	// Artists are in the order Spotify credits them.
+	Artists []Artist
+}
+
+var ErrTrackNotFound = fmt.Errorf("track not found")
+var ErrArtistNotFound = fmt.Errorf("artist not found")
+
+// GetUnknownTrackIDs filters ids down to the tracks that aren't in the
+// catalog yet.
+func (rp *PostgresRepository) GetUnknownTrackIDs(
+	ctx context.Context,
+	ids []string,
+) ([]string, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.catalog.get_unknown_track_ids")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		SELECT DISTINCT u.id FROM UNNEST($1::TEXT[]) AS u(id)
+		WHERE NOT EXISTS (SELECT 1 FROM tracks t WHERE t.id = u.id);
+		`
+	r, err := rp.db.Query(ctx, sql, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	unknown := []string{}
+	for r.Next() {
+		id := ""
+		if err := r.Scan(&id); err != nil {
+			return nil, err
+		}
+		unknown = append(unknown, id)
+	}
+
+	return unknown, r.Err()
+}
+
+// UpsertTracks writes tracks along with their albums and artists, updating
+// any that are already known.
+func (rp *PostgresRepository) UpsertTracks(
+	ctx context.Context,
+	tracks []Track,
+) error {
+	ctx, childSpan := tracer.Start(ctx, "repositories.catalog.upsert_tracks")
+	defer childSpan.End()
+
+	albums := map[string]*Album{}
+	artists := map[string]Artist{}
+	for _, t := range tracks {
+		if t.Album != nil {
+			albums[t.Album.ID] = t.Album
+		}
+		for _, a := range t.Artists {
+			artists[a.ID] = a
+		}
+	}
+
+	// Rows are locked in the order they are written, so always write them in
+	// ID order. Otherwise concurrent scrapes sharing artists or albums can
+	// deadlock.
+	albumIDs := make([]string, 0, len(albums))
+	for id := range albums {
+		albumIDs = append(albumIDs, id)
+	}
+	sort.Strings(albumIDs)
+	artistIDs := make([]string, 0, len(artists))
+	for id := range artists {
+		artistIDs = append(artistIDs, id)
+	}
+	sort.Strings(artistIDs)
+	tracks = append([]Track{}, tracks...)
+	sort.SliceStable(tracks, func(i, j int) bool {
+		return tracks[i].ID < tracks[j].ID
+	})
+
+	batch := &pgx.Batch{}
+	for _, id := range albumIDs {
+		a := albums[id]
+
+		//language=SQL
+		sql := `
+			INSERT INTO albums(id, name, album_type, release_date, image_url)
+			VALUES($1, $2, $3, $4, $5)
+			ON CONFLICT(id) DO UPDATE
+				SET name=$2, album_type=$3, release_date=$4, image_url=$5;
+			`
+		batch.Queue(sql, a.ID, a.Name, a.AlbumType, a.ReleaseDate, a.ImageURL)
+	}
+	for _, id := range artistIDs {
+		a := artists[id]
+
+		//language=SQL
+		sql := `INSERT INTO artists(id, name) VALUES($1, $2) ON CONFLICT(id) DO UPDATE SET name=$2;`
+		batch.Queue(sql, a.ID, a.Name)
+	}
+	for _, t := range tracks {
+		var albumID *string
+		if t.Album != nil {
+			albumID = &t.Album.ID
+		}
+
+		//language=SQL
+		sql := `
+			INSERT INTO tracks(id, name, album_id, duration_ms, explicit)
+			VALUES($1, $2, $3, $4, $5)
+			ON CONFLICT(id) DO UPDATE
+				SET name=$2, album_id=COALESCE($3, tracks.album_id), duration_ms=$4, explicit=$5;
+			`
+		batch.Queue(sql, t.ID, t.Name, albumID, t.DurationMs, t.Explicit)
+
+		for position, a := range t.Artists {
+			//language=SQL
+			sql := `
+				INSERT INTO track_artists(track_id, artist_id, position)
+				VALUES($1, $2, $3)
+				ON CONFLICT(track_id, artist_id) DO UPDATE SET position=$3;
+				`
+			batch.Queue(sql, t.ID, a.ID, position)
+		}
+	}
+
+	tx, err := rp.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	br := tx.SendBatch(ctx, batch)
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := br.Exec(); err != nil {
+			_ = br.Close()
+			return err
+		}
+	}
+	if err := br.Close(); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (rp *PostgresRepository) GetTrack(
+	ctx context.Context,
+	id string,
+) (*Track, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.catalog.get_track")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		SELECT
+			t.id, t.name, t.duration_ms, t.explicit,
+			al.id, al.name, al.album_type, al.release_date, al.image_url
+		FROM tracks t
+		LEFT JOIN albums al ON al.id = t.album_id
+		WHERE t.id = $1;
+		`
+
+	track := Track{}
+	var albumID, albumName, albumType, releaseDate, imageURL *string
+	err := rp.db.QueryRow(ctx, sql, id).Scan(
+		&track.ID,
+		&track.Name,
+		&track.DurationMs,
+		&track.Explicit,
+		&albumID,
+		&albumName,
+		&albumType,
+		&releaseDate,
+		&imageURL,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrTrackNotFound
+		}
+		return nil, err
+	}
+
+	if albumID != nil {
+		track.Album = &Album{
+			ID:          *albumID,
+			Name:        *albumName,
+			AlbumType:   *albumType,
+			ReleaseDate: *releaseDate,
+		}
+		if imageURL != nil {
+			track.Album.ImageURL = *imageURL
+		}
+	}
+
+	track.Artists, err = rp.getTrackArtists(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &track, nil
+}
+
+func (rp *PostgresRepository) getTrackArtists(ctx context.Context, trackID string) ([]Artist, error) {
+	//language=SQL
+	sql := `
+		SELECT a.id, a.name FROM track_artists ta
+		JOIN artists a ON a.id = ta.artist_id
+		WHERE ta.track_id = $1
+		ORDER BY ta.position;
+		`
+	r, err := rp.db.Query(ctx, sql, trackID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	artists := []Artist{}
+	for r.Next() {
+		a := Artist{}
+		if err := r.Scan(&a.ID, &a.Name); err != nil {
+			return nil, err
+		}
+		artists = append(artists, a)
+	}
+
+	return artists, r.Err()
+}
+
+type ArtistPlays struct {
+	Artist Artist
+	Plays  int
+}
+
+// GetTopArtists returns the artists the user has listened to most since the
+// given time.
+func (rp *PostgresRepository) GetTopArtists(
+	ctx context.Context,
+	discordID string,
+	since time.Time,
+	limit int,
+) ([]ArtistPlays, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.catalog.get_top_artists")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		SELECT a.id, a.name, COUNT(1) AS plays
+		FROM listens l
+		JOIN track_artists ta ON ta.track_id = l.song_id
+		JOIN artists a ON a.id = ta.artist_id
+		WHERE l.discord_id = $1 AND l.time >= $2
+		GROUP BY a.id, a.name
+		ORDER BY plays DESC, a.name
+		LIMIT $3;
+		`
+	r, err := rp.db.Query(ctx, sql, discordID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	results := []ArtistPlays{}
+	for r.Next() {
+		res := ArtistPlays{}
+		if err := r.Scan(&res.Artist.ID, &res.Artist.Name, &res.Plays); err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+
+	return results, r.Err()
+}
+
+// SearchListenedArtists returns the artists the user has listened to whose
+// name starts with prefix, most played first.
+func (rp *PostgresRepository) SearchListenedArtists(
+	ctx context.Context,
+	discordID string,
+	prefix string,
+	limit int,
+) ([]ArtistPlays, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.catalog.search_listened_artists")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		SELECT a.id, a.name, COUNT(1) AS plays
+		FROM listens l
+		JOIN track_artists ta ON ta.track_id = l.song_id
+		JOIN artists a ON a.id = ta.artist_id
+		WHERE l.discord_id = $1 AND a.name ILIKE $2
+		GROUP BY a.id, a.name
+		ORDER BY plays DESC, a.name
+		LIMIT $3;
+		`
+	r, err := rp.db.Query(ctx, sql, discordID, escapeLike(prefix)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	results := []ArtistPlays{}
+	for r.Next() {
+		res := ArtistPlays{}
+		if err := r.Scan(&res.Artist.ID, &res.Artist.Name, &res.Plays); err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+
+	return results, r.Err()
+}
+
+// GetArtistPlays returns how many times the user has played the artist since
+// the given time.
+func (rp *PostgresRepository) GetArtistPlays(
+	ctx context.Context,
+	discordID string,
+	artistID string,
+	since time.Time,
+) (*ArtistPlays, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.catalog.get_artist_plays")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		SELECT a.id, a.name, COUNT(l.song_id) AS plays
+		FROM artists a
+		LEFT JOIN track_artists ta ON ta.artist_id = a.id
+		LEFT JOIN listens l ON l.song_id = ta.track_id AND l.discord_id = $1 AND l.time >= $3
+		WHERE a.id = $2
+		GROUP BY a.id, a.name;
+		`
+
+	res := ArtistPlays{}
+	err := rp.db.QueryRow(ctx, sql, discordID, artistID, since).Scan(&res.Artist.ID, &res.Artist.Name, &res.Plays)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrArtistNotFound
+		}
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escapes s so it is matched literally in a LIKE pattern.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+type TrackPlays struct {
+	Track Track
+	Plays int
+}
+
+// GetTopTracks returns the tracks the user has listened to most since the
+// given time. The album is not populated.
+func (rp *PostgresRepository) GetTopTracks(
+	ctx context.Context,
+	discordID string,
+	since time.Time,
+	limit int,
+) ([]TrackPlays, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.catalog.get_top_tracks")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		SELECT
+			t.id, t.name, t.duration_ms, t.explicit,
+			ARRAY(
+				SELECT a.id FROM track_artists ta
+				JOIN artists a ON a.id = ta.artist_id
+				WHERE ta.track_id = t.id ORDER BY ta.position
+			) AS artist_ids,
+			ARRAY(
+				SELECT a.name FROM track_artists ta
+				JOIN artists a ON a.id = ta.artist_id
+				WHERE ta.track_id = t.id ORDER BY ta.position
+			) AS artist_names,
+			COUNT(1) AS plays
+		FROM listens l
+		JOIN tracks t ON t.id = l.song_id
+		WHERE l.discord_id = $1 AND l.time >= $2
+		GROUP BY t.id
+		ORDER BY plays DESC, t.name
+		LIMIT $3;
+		`
+	r, err := rp.db.Query(ctx, sql, discordID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	results := []TrackPlays{}
+	for r.Next() {
+		res := TrackPlays{}
+		artistIDs, artistNames := []string{}, []string{}
+		err := r.Scan(
+			&res.Track.ID,
+			&res.Track.Name,
+			&res.Track.DurationMs,
+			&res.Track.Explicit,
+			&artistIDs,
+			&artistNames,
+			&res.Plays,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range artistIDs {
+			res.Track.Artists = append(res.Track.Artists, Artist{ID: artistIDs[i], Name: artistNames[i]})
+		}
+		results = append(results, res)
+	}
+
+	return results, r.Err()
+}
@@ -0,0 +1,260 @@
+package catalog
+
+import (
+	"context"
+	"oscen/repositories/listens"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Repository stores track, album and artist metadata, and answers questions
+// about the listens of tracks in it.
+type Repository interface {
+	GetUnknownTrackIDs(ctx context.Context, ids []string) ([]string, error)
+	UpsertTracks(ctx context.Context, tracks []Track) error
+	GetTrack(ctx context.Context, id string) (*Track, error)
+	GetTopArtists(ctx context.Context, discordID string, since time.Time, limit int) ([]ArtistPlays, error)
+	SearchListenedArtists(ctx context.Context, discordID string, prefix string, limit int) ([]ArtistPlays, error)
+	GetArtistPlays(ctx context.Context, discordID string, artistID string, since time.Time) (*ArtistPlays, error)
+	GetTopTracks(ctx context.Context, discordID string, since time.Time, limit int) ([]TrackPlays, error)
+}
+
+var (
+	_ Repository = (*PostgresRepository)(nil)
+	_ Repository = (*MemoryRepository)(nil)
+)
+
+// MemoryRepository is a Repository that doesn't need Postgres, for tests.
+// Listens are read from a listens.MemoryRepository, as Postgres reads them
+// from the listens table.
+type MemoryRepository struct {
+	listens *listens.MemoryRepository
+
+	mu      sync.Mutex
+	albums  map[string]Album
+	artists map[string]Artist
+	tracks  map[string]*memoryTrack
+}
+
+// memoryTrack is a row of tracks along with its track_artists.
+type memoryTrack struct {
+	track   Track
+	albumID string
+	// positions maps the ID of each of the track's artists to where they
+	// are credited.
+	positions map[string]int
+}
+
+func NewMemoryRepository(listensRepo *listens.MemoryRepository) *MemoryRepository {
+	return &MemoryRepository{
+		listens: listensRepo,
+		albums:  map[string]Album{},
+		artists: map[string]Artist{},
+		tracks:  map[string]*memoryTrack{},
+	}
+}
+
+// artistIDs returns the IDs of the track's artists in the order they are
+// credited.
+func (t *memoryTrack) artistIDs() []string {
+	ids := []string{}
+	for id := range t.positions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return t.positions[ids[i]] < t.positions[ids[j]]
+	})
+	return ids
+}
+
+func (rp *MemoryRepository) GetUnknownTrackIDs(_ context.Context, ids []string) ([]string, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	unknown := []string{}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if _, ok := rp.tracks[id]; ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		unknown = append(unknown, id)
+	}
+	return unknown, nil
+}
+
+func (rp *MemoryRepository) UpsertTracks(_ context.Context, tracks []Track) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	for _, t := range tracks {
+		existing, ok := rp.tracks[t.ID]
+		if !ok {
+			existing = &memoryTrack{positions: map[string]int{}}
+			rp.tracks[t.ID] = existing
+		}
+		existing.track = Track{ID: t.ID, Name: t.Name, DurationMs: t.DurationMs, Explicit: t.Explicit}
+
+		// Like the COALESCE in Postgres, the album is kept if we aren't
+		// told it.
+		if t.Album != nil {
+			rp.albums[t.Album.ID] = *t.Album
+			existing.albumID = t.Album.ID
+		}
+		for position, a := range t.Artists {
+			rp.artists[a.ID] = a
+			existing.positions[a.ID] = position
+		}
+	}
+	return nil
+}
+
+func (rp *MemoryRepository) GetTrack(_ context.Context, id string) (*Track, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	t, ok := rp.tracks[id]
+	if !ok {
+		return nil, ErrTrackNotFound
+	}
+
+	track := t.track
+	if album, ok := rp.albums[t.albumID]; ok {
+		track.Album = &album
+	}
+	track.Artists = []Artist{}
+	for _, artistID := range t.artistIDs() {
+		track.Artists = append(track.Artists, rp.artists[artistID])
+	}
+	return &track, nil
+}
+
+// artistPlays counts the user's plays of each artist, of the listens for
+// which include returns true.
+func (rp *MemoryRepository) artistPlays(
+	discordID string,
+	include func(entry listens.BatchWriteListenEntry) bool,
+) map[string]int {
+	plays := map[string]int{}
+	for _, entry := range rp.listens.UserListens(discordID) {
+		t, ok := rp.tracks[entry.TrackID]
+		if !ok || !include(entry) {
+			continue
+		}
+		for artistID := range t.positions {
+			plays[artistID]++
+		}
+	}
+	return plays
+}
+
+// topArtists sorts plays like the Postgres queries, most played first.
+func (rp *MemoryRepository) topArtists(plays map[string]int, keep func(a Artist) bool, limit int) []ArtistPlays {
+	results := []ArtistPlays{}
+	for artistID, n := range plays {
+		a := rp.artists[artistID]
+		if keep(a) {
+			results = append(results, ArtistPlays{Artist: a, Plays: n})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Plays != results[j].Plays {
+			return results[i].Plays > results[j].Plays
+		}
+		return results[i].Artist.Name < results[j].Artist.Name
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func (rp *MemoryRepository) GetTopArtists(
+	_ context.Context,
+	discordID string,
+	since time.Time,
+	limit int,
+) ([]ArtistPlays, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	plays := rp.artistPlays(discordID, func(entry listens.BatchWriteListenEntry) bool {
+		return !entry.PlayedAt.Before(since)
+	})
+	return rp.topArtists(plays, func(Artist) bool { return true }, limit), nil
+}
+
+func (rp *MemoryRepository) SearchListenedArtists(
+	_ context.Context,
+	discordID string,
+	prefix string,
+	limit int,
+) ([]ArtistPlays, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	plays := rp.artistPlays(discordID, func(listens.BatchWriteListenEntry) bool { return true })
+	prefix = strings.ToLower(prefix)
+	return rp.topArtists(plays, func(a Artist) bool {
+		return strings.HasPrefix(strings.ToLower(a.Name), prefix)
+	}, limit), nil
+}
+
+func (rp *MemoryRepository) GetArtistPlays(
+	_ context.Context,
+	discordID string,
+	artistID string,
+	since time.Time,
+) (*ArtistPlays, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	a, ok := rp.artists[artistID]
+	if !ok {
+		return nil, ErrArtistNotFound
+	}
+
+	plays := rp.artistPlays(discordID, func(entry listens.BatchWriteListenEntry) bool {
+		return !entry.PlayedAt.Before(since)
+	})
+	return &ArtistPlays{Artist: a, Plays: plays[artistID]}, nil
+}
+
+func (rp *MemoryRepository) GetTopTracks(
+	_ context.Context,
+	discordID string,
+	since time.Time,
+	limit int,
+) ([]TrackPlays, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	plays := map[string]int{}
+	for _, entry := range rp.listens.UserListens(discordID) {
+		if _, ok := rp.tracks[entry.TrackID]; ok && !entry.PlayedAt.Before(since) {
+			plays[entry.TrackID]++
+		}
+	}
+
+	results := []TrackPlays{}
+	for trackID, n := range plays {
+		t := rp.tracks[trackID]
+		track := t.track
+		for _, artistID := range t.artistIDs() {
+			track.Artists = append(track.Artists, rp.artists[artistID])
+		}
+		results = append(results, TrackPlays{Track: track, Plays: n})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Plays != results[j].Plays {
+			return results[i].Plays > results[j].Plays
+		}
+		return results[i].Track.Name < results[j].Track.Name
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
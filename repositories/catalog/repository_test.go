@@ -0,0 +1,187 @@
+package catalog
+
+import (
+	"context"
+	"oscen/repositories/listens"
+	"oscen/repositories/repotest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T) (Repository, listens.Repository) {
+		listensRepo := listens.NewMemoryRepository()
+		return NewMemoryRepository(listensRepo), listensRepo
+	})
+}
+
+func TestPostgresRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T) (Repository, listens.Repository) {
+		db := repotest.Postgres(t)
+		return NewPostgresRepository(db), listens.NewPostgresRepository(db)
+	})
+}
+
+// testRepository checks a Repository behaves as the rest of Oscen expects.
+// newRepos must return an empty repository, along with the listens it reads.
+func testRepository(t *testing.T, newRepos func(t *testing.T) (Repository, listens.Repository)) {
+	ctx := context.Background()
+	base := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	boc := Artist{ID: "boc", Name: "Boards of Canada"}
+	aphex := Artist{ID: "aphex", Name: "Aphex Twin"}
+	autechre := Artist{ID: "autechre", Name: "Autechre"}
+	mhtrtc := &Album{ID: "mhtrtc", Name: "Music Has the Right to Children", AlbumType: "album", ReleaseDate: "1998-04-20"}
+
+	roygbiv := Track{ID: "roygbiv", Name: "Roygbiv", DurationMs: 151000, Album: mhtrtc, Artists: []Artist{boc}}
+	olson := Track{ID: "olson", Name: "Olson", DurationMs: 91000, Album: mhtrtc, Artists: []Artist{boc}}
+	xtal := Track{ID: "xtal", Name: "Xtal", DurationMs: 294000, Artists: []Artist{aphex}}
+	collab := Track{ID: "collab", Name: "Collab", DurationMs: 200000, Artists: []Artist{autechre, aphex}}
+
+	// listen writes a listen of each track, an hour apart going back from
+	// base.
+	listen := func(t *testing.T, listensRepo listens.Repository, discordID string, trackIDs ...string) {
+		entries := []listens.BatchWriteListenEntry{}
+		for i, id := range trackIDs {
+			entries = append(entries, listens.BatchWriteListenEntry{
+				TrackID:  id,
+				PlayedAt: base.Add(-time.Duration(i) * time.Hour),
+			})
+		}
+		_, err := listensRepo.BatchWriteListens(ctx, discordID, entries)
+		require.NoError(t, err)
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		rp, _ := newRepos(t)
+
+		unknown, err := rp.GetUnknownTrackIDs(ctx, []string{"roygbiv"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"roygbiv"}, unknown)
+
+		_, err = rp.GetTrack(ctx, "roygbiv")
+		assert.Equal(t, ErrTrackNotFound, err)
+
+		_, err = rp.GetArtistPlays(ctx, "1", "boc", base)
+		assert.Equal(t, ErrArtistNotFound, err)
+
+		artists, err := rp.GetTopArtists(ctx, "1", base, 10)
+		require.NoError(t, err)
+		assert.Empty(t, artists)
+
+		artists, err = rp.SearchListenedArtists(ctx, "1", "b", 10)
+		require.NoError(t, err)
+		assert.Empty(t, artists)
+
+		tracks, err := rp.GetTopTracks(ctx, "1", base, 10)
+		require.NoError(t, err)
+		assert.Empty(t, tracks)
+
+		require.NoError(t, rp.UpsertTracks(ctx, nil))
+	})
+
+	t.Run("tracks", func(t *testing.T) {
+		rp, _ := newRepos(t)
+
+		require.NoError(t, rp.UpsertTracks(ctx, []Track{roygbiv, xtal, collab}))
+
+		unknown, err := rp.GetUnknownTrackIDs(ctx, []string{"olson", "roygbiv", "olson"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"olson"}, unknown)
+
+		track, err := rp.GetTrack(ctx, "roygbiv")
+		require.NoError(t, err)
+		assert.Equal(t, &roygbiv, track)
+
+		// Artists keep the order they are credited in.
+		track, err = rp.GetTrack(ctx, "collab")
+		require.NoError(t, err)
+		assert.Equal(t, []Artist{autechre, aphex}, track.Artists)
+		assert.Nil(t, track.Album)
+
+		// Updates replace what we knew, except for the album if we aren't
+		// told it.
+		renamed := roygbiv
+		renamed.Name = "Roygbiv (Remastered)"
+		renamed.Album = nil
+		require.NoError(t, rp.UpsertTracks(ctx, []Track{renamed}))
+
+		track, err = rp.GetTrack(ctx, "roygbiv")
+		require.NoError(t, err)
+		assert.Equal(t, "Roygbiv (Remastered)", track.Name)
+		assert.Equal(t, mhtrtc, track.Album)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		rp, listensRepo := newRepos(t)
+		require.NoError(t, rp.UpsertTracks(ctx, []Track{roygbiv, olson, xtal, collab}))
+
+		listen(t, listensRepo, "1", "roygbiv", "olson", "roygbiv", "xtal", "collab", "unknown")
+		listen(t, listensRepo, "2", "xtal", "xtal", "xtal")
+
+		artists, err := rp.GetTopArtists(ctx, "1", base.Add(-24*time.Hour), 10)
+		require.NoError(t, err)
+		assert.Equal(t, []ArtistPlays{
+			{Artist: boc, Plays: 3},
+			{Artist: aphex, Plays: 2},
+			{Artist: autechre, Plays: 1},
+		}, artists)
+
+		// Only listens since the given time count.
+		artists, err = rp.GetTopArtists(ctx, "1", base.Add(-time.Hour), 1)
+		require.NoError(t, err)
+		assert.Equal(t, []ArtistPlays{{Artist: boc, Plays: 2}}, artists)
+
+		tracks, err := rp.GetTopTracks(ctx, "1", base.Add(-24*time.Hour), 3)
+		require.NoError(t, err)
+		require.Len(t, tracks, 3)
+		assert.Equal(t, "roygbiv", tracks[0].Track.ID)
+		assert.Equal(t, 2, tracks[0].Plays)
+		assert.Equal(t, []Artist{boc}, tracks[0].Track.Artists)
+		assert.Nil(t, tracks[0].Track.Album)
+		// Ties are ordered by name.
+		assert.Equal(t, "collab", tracks[1].Track.ID)
+		assert.Equal(t, []Artist{autechre, aphex}, tracks[1].Track.Artists)
+		assert.Equal(t, "olson", tracks[2].Track.ID)
+
+		plays, err := rp.GetArtistPlays(ctx, "1", "aphex", base.Add(-24*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, &ArtistPlays{Artist: aphex, Plays: 2}, plays)
+
+		// Artists the user hasn't played are still found.
+		plays, err = rp.GetArtistPlays(ctx, "3", "aphex", base.Add(-24*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, &ArtistPlays{Artist: aphex, Plays: 0}, plays)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		rp, listensRepo := newRepos(t)
+		underscore := Artist{ID: "underscore", Name: "A_B"}
+		require.NoError(t, rp.UpsertTracks(ctx, []Track{
+			xtal,
+			collab,
+			{ID: "underscore", Name: "Underscore", Artists: []Artist{underscore}},
+		}))
+		listen(t, listensRepo, "1", "xtal", "collab", "underscore")
+
+		artists, err := rp.SearchListenedArtists(ctx, "1", "a", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []ArtistPlays{
+			{Artist: aphex, Plays: 2},
+			{Artist: underscore, Plays: 1},
+			{Artist: autechre, Plays: 1},
+		}, artists)
+
+		// Patterns are matched literally.
+		artists, err = rp.SearchListenedArtists(ctx, "1", "A_", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []ArtistPlays{{Artist: underscore, Plays: 1}}, artists)
+
+		artists, err = rp.SearchListenedArtists(ctx, "2", "a", 10)
+		require.NoError(t, err)
+		assert.Empty(t, artists)
+	})
+}
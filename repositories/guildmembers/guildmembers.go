@@ -0,0 +1,118 @@
+// Package guildmembers tracks who is in each guild Oscen is in, so commands
+// don't need to page through Discord's API to find out.
+package guildmembers
+
+import (
+	"context"
+	"oscen/tracer"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type PostgresRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+// AddMembers records the users as members of the guild, last seen at the
+// given time.
+func (rp *PostgresRepository) AddMembers(
+	ctx context.Context,
+	guildID string,
+	discordIDs []string,
+	seenAt time.Time,
+) error {
+	ctx, childSpan := tracer.Start(ctx, "repositories.guild_members.add_members")
+	defer childSpan.End()
+
+	if len(discordIDs) == 0 {
+		return nil
+	}
+
+	//language=SQL
+	sql := `
+		INSERT INTO guild_members(guild_id, discord_id, seen_at)
+		SELECT $1, UNNEST($2::TEXT[]), $3
+		ON CONFLICT (guild_id, discord_id) DO UPDATE SET seen_at = EXCLUDED.seen_at;
+		`
+	_, err := rp.db.Exec(ctx, sql, guildID, discordIDs, seenAt)
+	return err
+}
+
+func (rp *PostgresRepository) RemoveMember(
+	ctx context.Context,
+	guildID string,
+	discordID string,
+) error {
+	ctx, childSpan := tracer.Start(ctx, "repositories.guild_members.remove_member")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := "DELETE FROM guild_members WHERE guild_id = $1 AND discord_id = $2;"
+	_, err := rp.db.Exec(ctx, sql, guildID, discordID)
+	return err
+}
+
+// PruneMembers removes members of the guild that haven't been seen since the
+// given time. After fetching every member, this drops those who left while
+// we weren't listening.
+func (rp *PostgresRepository) PruneMembers(
+	ctx context.Context,
+	guildID string,
+	seenBefore time.Time,
+) (int64, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.guild_members.prune_members")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := "DELETE FROM guild_members WHERE guild_id = $1 AND seen_at < $2;"
+	tag, err := rp.db.Exec(ctx, sql, guildID, seenBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	childSpan.SetAttributes(attribute.Int64("io.oscen.guild_members.pruned", tag.RowsAffected()))
+	return tag.RowsAffected(), nil
+}
+
+// RemoveGuild forgets every member of a guild Oscen has left.
+func (rp *PostgresRepository) RemoveGuild(ctx context.Context, guildID string) error {
+	ctx, childSpan := tracer.Start(ctx, "repositories.guild_members.remove_guild")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := "DELETE FROM guild_members WHERE guild_id = $1;"
+	_, err := rp.db.Exec(ctx, sql, guildID)
+	return err
+}
+
+// GetMemberIDs returns the Discord IDs of everyone in the guild.
+func (rp *PostgresRepository) GetMemberIDs(ctx context.Context, guildID string) ([]string, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.guild_members.get_member_ids")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := "SELECT discord_id FROM guild_members WHERE guild_id = $1 ORDER BY discord_id;"
+	r, err := rp.db.Query(ctx, sql, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	ids := []string{}
+	for r.Next() {
+		var id string
+		if err := r.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, r.Err()
+}
@@ -0,0 +1,99 @@
+package guildmembers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Repository stores which users are in which guilds.
+type Repository interface {
+	AddMembers(ctx context.Context, guildID string, discordIDs []string, seenAt time.Time) error
+	RemoveMember(ctx context.Context, guildID string, discordID string) error
+	PruneMembers(ctx context.Context, guildID string, seenBefore time.Time) (int64, error)
+	RemoveGuild(ctx context.Context, guildID string) error
+	GetMemberIDs(ctx context.Context, guildID string) ([]string, error)
+}
+
+var (
+	_ Repository = (*PostgresRepository)(nil)
+	_ Repository = (*MemoryRepository)(nil)
+)
+
+// MemoryRepository is a Repository that doesn't need Postgres, for tests.
+type MemoryRepository struct {
+	mu sync.Mutex
+	// seenAt is keyed by guild ID then Discord ID.
+	seenAt map[string]map[string]time.Time
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{seenAt: map[string]map[string]time.Time{}}
+}
+
+func (rp *MemoryRepository) AddMembers(
+	_ context.Context,
+	guildID string,
+	discordIDs []string,
+	seenAt time.Time,
+) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if len(discordIDs) == 0 {
+		return nil
+	}
+
+	members, ok := rp.seenAt[guildID]
+	if !ok {
+		members = map[string]time.Time{}
+		rp.seenAt[guildID] = members
+	}
+	for _, discordID := range discordIDs {
+		members[discordID] = seenAt
+	}
+	return nil
+}
+
+func (rp *MemoryRepository) RemoveMember(_ context.Context, guildID string, discordID string) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	delete(rp.seenAt[guildID], discordID)
+	return nil
+}
+
+func (rp *MemoryRepository) PruneMembers(_ context.Context, guildID string, seenBefore time.Time) (int64, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	pruned := int64(0)
+	for discordID, seenAt := range rp.seenAt[guildID] {
+		if seenAt.Before(seenBefore) {
+			delete(rp.seenAt[guildID], discordID)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+func (rp *MemoryRepository) RemoveGuild(_ context.Context, guildID string) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	delete(rp.seenAt, guildID)
+	return nil
+}
+
+func (rp *MemoryRepository) GetMemberIDs(_ context.Context, guildID string) ([]string, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	ids := []string{}
+	for discordID := range rp.seenAt[guildID] {
+		ids = append(ids, discordID)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
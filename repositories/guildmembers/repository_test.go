@@ -0,0 +1,96 @@
+package guildmembers
+
+import (
+	"context"
+	"oscen/repositories/repotest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		return NewMemoryRepository()
+	})
+}
+
+func TestPostgresRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		return NewPostgresRepository(repotest.Postgres(t))
+	})
+}
+
+// testRepository checks a Repository behaves as the rest of Oscen expects.
+// newRepo must return an empty repository.
+func testRepository(t *testing.T, newRepo func(t *testing.T) Repository) {
+	ctx := context.Background()
+	base := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("empty", func(t *testing.T) {
+		rp := newRepo(t)
+
+		ids, err := rp.GetMemberIDs(ctx, "1")
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+
+		require.NoError(t, rp.AddMembers(ctx, "1", nil, base))
+		require.NoError(t, rp.RemoveMember(ctx, "1", "10"))
+		require.NoError(t, rp.RemoveGuild(ctx, "1"))
+
+		pruned, err := rp.PruneMembers(ctx, "1", base)
+		require.NoError(t, err)
+		assert.Zero(t, pruned)
+	})
+
+	t.Run("members", func(t *testing.T) {
+		rp := newRepo(t)
+
+		require.NoError(t, rp.AddMembers(ctx, "1", []string{"11", "10"}, base))
+		// Adding a member again is fine.
+		require.NoError(t, rp.AddMembers(ctx, "1", []string{"10", "12"}, base))
+		require.NoError(t, rp.AddMembers(ctx, "2", []string{"10"}, base))
+
+		ids, err := rp.GetMemberIDs(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10", "11", "12"}, ids)
+
+		require.NoError(t, rp.RemoveMember(ctx, "1", "11"))
+		ids, err = rp.GetMemberIDs(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10", "12"}, ids)
+
+		// Membership is per guild.
+		require.NoError(t, rp.RemoveGuild(ctx, "1"))
+		ids, err = rp.GetMemberIDs(ctx, "1")
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+
+		ids, err = rp.GetMemberIDs(ctx, "2")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10"}, ids)
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		rp := newRepo(t)
+
+		require.NoError(t, rp.AddMembers(ctx, "1", []string{"10", "11", "12"}, base))
+		require.NoError(t, rp.AddMembers(ctx, "2", []string{"10"}, base))
+		// A later sync only sees some of them.
+		require.NoError(t, rp.AddMembers(ctx, "1", []string{"10", "12"}, base.Add(time.Hour)))
+
+		pruned, err := rp.PruneMembers(ctx, "1", base.Add(time.Hour))
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, pruned)
+
+		ids, err := rp.GetMemberIDs(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10", "12"}, ids)
+
+		// Other guilds aren't pruned.
+		ids, err = rp.GetMemberIDs(ctx, "2")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10"}, ids)
+	})
+}
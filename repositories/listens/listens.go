@@ -5,6 +5,8 @@ import (
 	"oscen/tracer"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
@@ -77,47 +79,269 @@ func (rp *PostgresRepository) GetUserListenCount(
 	return listenCount, nil
 }
 
+// GetUserListeningTime returns how long the user has spent listening since
+// the given time.
+func (rp *PostgresRepository) GetUserListeningTime(
+	ctx context.Context,
+	discordID string,
+	since time.Time,
+) (time.Duration, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.listens.get_user_listening_time")
+	defer childSpan.End()
+
+	var totalMs int64
+
+	//language=SQL
+	sql := "SELECT COALESCE(SUM(duration_ms), 0) FROM listens WHERE discord_id = $1 AND time >= $2;"
+	row := rp.db.QueryRow(ctx, sql, discordID, since)
+	if err := row.Scan(&totalMs); err != nil {
+		return 0, err
+	}
+
+	return time.Duration(totalMs) * time.Millisecond, nil
+}
+
+type ContextListeningTime struct {
+	ContextType   string
+	ContextURI    string
+	Listens       int
+	ListeningTime time.Duration
+}
+
+// GetTopContexts returns the albums, artists and playlists the user has spent
+// the longest listening to since the given time.
+func (rp *PostgresRepository) GetTopContexts(
+	ctx context.Context,
+	discordID string,
+	since time.Time,
+	limit int,
+) ([]ContextListeningTime, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.listens.get_top_contexts")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		SELECT context_type, context_uri, COUNT(1), COALESCE(SUM(duration_ms), 0) AS total_ms
+		FROM listens
+		WHERE discord_id = $1 AND time >= $2 AND context_uri IS NOT NULL
+		GROUP BY context_type, context_uri
+		ORDER BY total_ms DESC
+		LIMIT $3;
+		`
+	r, err := rp.db.Query(ctx, sql, discordID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	results := []ContextListeningTime{}
+	for r.Next() {
+		res := ContextListeningTime{}
+		var totalMs int64
+		if err := r.Scan(&res.ContextType, &res.ContextURI, &res.Listens, &totalMs); err != nil {
+			return nil, err
+		}
+		res.ListeningTime = time.Duration(totalMs) * time.Millisecond
+		results = append(results, res)
+	}
+
+	return results, r.Err()
+}
+
+type LeaderboardEntry struct {
+	DiscordID     string
+	Listens       int
+	ListeningTime time.Duration
+}
+
+// GetLeaderboard ranks the given users by how many listens they have since
+// the given time. Users without any listens are left out, ties are broken by
+// Discord ID so pages are stable.
+func (rp *PostgresRepository) GetLeaderboard(
+	ctx context.Context,
+	discordIDs []string,
+	since time.Time,
+	limit int,
+	offset int,
+) ([]LeaderboardEntry, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.listens.get_leaderboard")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		SELECT discord_id, COUNT(1) AS listens, COALESCE(SUM(duration_ms), 0)
+		FROM listens
+		WHERE discord_id = ANY($1) AND time >= $2
+		GROUP BY discord_id
+		ORDER BY listens DESC, discord_id
+		LIMIT $3 OFFSET $4;
+		`
+	r, err := rp.db.Query(ctx, sql, discordIDs, since, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	results := []LeaderboardEntry{}
+	for r.Next() {
+		res := LeaderboardEntry{}
+		var totalMs int64
+		if err := r.Scan(&res.DiscordID, &res.Listens, &totalMs); err != nil {
+			return nil, err
+		}
+		res.ListeningTime = time.Duration(totalMs) * time.Millisecond
+		results = append(results, res)
+	}
+
+	return results, r.Err()
+}
+
 type BatchWriteListenEntry struct {
 	TrackID  string
 	PlayedAt time.Time
+	// ContextType is where the track was played from, one of album, artist
+	// or playlist. ContextURI is the Spotify URI of the context. Both are
+	// empty if Spotify didn't tell us.
+	ContextType string
+	ContextURI  string
+	// DurationMs is how long the track was listened to for. Spotify only
+	// tells us this for history exports, otherwise it is the track length.
+	DurationMs int
 }
 
+// BatchWriteListens writes entries in a single round trip, skipping any the
+// user already has. It returns the number of listens that were new. For
+// thousands of entries, ImportListens is faster.
 func (rp *PostgresRepository) BatchWriteListens(
 	ctx context.Context,
 	discordID string,
 	entries []BatchWriteListenEntry,
-) error {
+) (int64, error) {
 	ctx, childSpan := tracer.Start(ctx, "repositories.listens.batch_write_listens")
 	defer childSpan.End()
 
-	// TODO: Use an actual batch rather than write loads as a transaction
-	tx, err := rp.db.BeginTx(ctx, pgx.TxOptions{})
-	if err != nil {
-		return err
+	if len(entries) == 0 {
+		return 0, nil
 	}
-	defer func() {
-		_ = tx.Rollback(ctx)
-	}()
 
 	//language=SQL
-	sql := `INSERT INTO listens(discord_id, song_id, time) VALUES($1, $2, $3) ON CONFLICT DO NOTHING;`
+	sql := `
+		INSERT INTO listens(discord_id, song_id, time, context_type, context_uri, duration_ms)
+		VALUES($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)
+		ON CONFLICT DO NOTHING;
+		`
 
+	batch := &pgx.Batch{}
 	for _, entry := range entries {
-		_, err = tx.Exec(ctx,
+		batch.Queue(
 			sql,
 			discordID,
 			entry.TrackID,
 			entry.PlayedAt,
+			entry.ContextType,
+			entry.ContextURI,
+			entry.DurationMs,
 		)
+	}
+
+	// A batch runs in an implicit transaction, so either every entry is
+	// written or none are.
+	br := rp.db.SendBatch(ctx, batch)
+	inserted := int64(0)
+	for range entries {
+		tag, err := br.Exec()
 		if err != nil {
-			return err
+			_ = br.Close()
+			return 0, err
 		}
+		inserted += tag.RowsAffected()
+	}
+	if err := br.Close(); err != nil {
+		return 0, err
+	}
+
+	childSpan.SetAttributes(attribute.Int64("io.oscen.listens.inserted", inserted))
+	return inserted, nil
+}
+
+// ImportListens bulk loads entries with COPY, skipping any the user already
+// has. History exports only give times to the second, so entries within a
+// second of a scraped listen of the same track are skipped too. It returns
+// the number of listens that were new.
+func (rp *PostgresRepository) ImportListens(
+	ctx context.Context,
+	discordID string,
+	entries []BatchWriteListenEntry,
+) (int64, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.listens.import_listens")
+	defer childSpan.End()
+
+	tx, err := rp.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	// COPY can't skip conflicting rows, so load into a staging table and let
+	// the INSERT deal with them.
+	//language=SQL
+	sql := `CREATE TEMPORARY TABLE listens_import (LIKE listens INCLUDING DEFAULTS) ON COMMIT DROP;`
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return 0, err
 	}
 
-	err = tx.Commit(ctx)
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"listens_import"},
+		[]string{"discord_id", "song_id", "time", "context_type", "context_uri", "duration_ms"},
+		pgx.CopyFromSlice(len(entries), func(i int) ([]interface{}, error) {
+			entry := entries[i]
+			return []interface{}{
+				discordID,
+				entry.TrackID,
+				entry.PlayedAt,
+				nullIfEmpty(entry.ContextType),
+				nullIfEmpty(entry.ContextURI),
+				entry.DurationMs,
+			}, nil
+		}),
+	)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	//language=SQL
+	sql = `
+		INSERT INTO listens(discord_id, song_id, time, context_type, context_uri, duration_ms)
+		SELECT i.discord_id, i.song_id, i.time, i.context_type, i.context_uri, i.duration_ms
+		FROM listens_import i
+		WHERE NOT EXISTS (
+			SELECT 1 FROM listens l
+			WHERE l.discord_id = i.discord_id
+			  AND l.song_id = i.song_id
+			  AND l.time > i.time - INTERVAL '1 second'
+			  AND l.time < i.time + INTERVAL '1 second'
+		)
+		ON CONFLICT DO NOTHING;
+		`
+	tag, err := tx.Exec(ctx, sql)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	childSpan.SetAttributes(attribute.Int64("io.oscen.listens.inserted", tag.RowsAffected()))
+	return tag.RowsAffected(), nil
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
 }
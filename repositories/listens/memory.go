@@ -0,0 +1,261 @@
+package listens
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Repository stores the tracks users have listened to.
+type Repository interface {
+	GetUsersLastListenTime(ctx context.Context, discordID string) (*time.Time, error)
+	GetSongListenCount(ctx context.Context, discordID string, songID string) (int, error)
+	GetUserListenCount(ctx context.Context, discordID string) (int, error)
+	GetUserListeningTime(ctx context.Context, discordID string, since time.Time) (time.Duration, error)
+	GetTopContexts(ctx context.Context, discordID string, since time.Time, limit int) ([]ContextListeningTime, error)
+	GetLeaderboard(ctx context.Context, discordIDs []string, since time.Time, limit int, offset int) ([]LeaderboardEntry, error)
+	BatchWriteListens(ctx context.Context, discordID string, entries []BatchWriteListenEntry) (int64, error)
+	ImportListens(ctx context.Context, discordID string, entries []BatchWriteListenEntry) (int64, error)
+}
+
+var (
+	_ Repository = (*PostgresRepository)(nil)
+	_ Repository = (*MemoryRepository)(nil)
+)
+
+// MemoryRepository is a Repository that doesn't need Postgres, for tests.
+type MemoryRepository struct {
+	mu      sync.Mutex
+	listens map[listenKey]BatchWriteListenEntry
+}
+
+// listenKey matches the listens primary key. Postgres stores times to the
+// microsecond, so listens differing by less are the same listen.
+type listenKey struct {
+	discordID string
+	songID    string
+	time      int64
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{listens: map[listenKey]BatchWriteListenEntry{}}
+}
+
+// userListens calls fn with each of the user's listens.
+func (rp *MemoryRepository) userListens(discordID string, fn func(entry BatchWriteListenEntry)) {
+	for key, entry := range rp.listens {
+		if key.discordID == discordID {
+			fn(entry)
+		}
+	}
+}
+
+// UserListens returns the user's listens, for other in memory repositories
+// whose Postgres queries join against listens.
+func (rp *MemoryRepository) UserListens(discordID string) []BatchWriteListenEntry {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	entries := []BatchWriteListenEntry{}
+	rp.userListens(discordID, func(entry BatchWriteListenEntry) {
+		entries = append(entries, entry)
+	})
+	return entries
+}
+
+func (rp *MemoryRepository) GetUsersLastListenTime(_ context.Context, discordID string) (*time.Time, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	var last *time.Time
+	rp.userListens(discordID, func(entry BatchWriteListenEntry) {
+		if last == nil || entry.PlayedAt.After(*last) {
+			playedAt := entry.PlayedAt
+			last = &playedAt
+		}
+	})
+	return last, nil
+}
+
+func (rp *MemoryRepository) GetSongListenCount(_ context.Context, discordID string, songID string) (int, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	count := 0
+	rp.userListens(discordID, func(entry BatchWriteListenEntry) {
+		if entry.TrackID == songID {
+			count++
+		}
+	})
+	return count, nil
+}
+
+func (rp *MemoryRepository) GetUserListenCount(_ context.Context, discordID string) (int, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	count := 0
+	rp.userListens(discordID, func(BatchWriteListenEntry) {
+		count++
+	})
+	return count, nil
+}
+
+func (rp *MemoryRepository) GetUserListeningTime(_ context.Context, discordID string, since time.Time) (time.Duration, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	var totalMs int64
+	rp.userListens(discordID, func(entry BatchWriteListenEntry) {
+		if !entry.PlayedAt.Before(since) {
+			totalMs += int64(entry.DurationMs)
+		}
+	})
+	return time.Duration(totalMs) * time.Millisecond, nil
+}
+
+func (rp *MemoryRepository) GetTopContexts(
+	_ context.Context,
+	discordID string,
+	since time.Time,
+	limit int,
+) ([]ContextListeningTime, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	type contextKey struct{ contextType, contextURI string }
+	byContext := map[contextKey]*ContextListeningTime{}
+	rp.userListens(discordID, func(entry BatchWriteListenEntry) {
+		if entry.PlayedAt.Before(since) || entry.ContextURI == "" {
+			return
+		}
+		key := contextKey{entry.ContextType, entry.ContextURI}
+		res, ok := byContext[key]
+		if !ok {
+			res = &ContextListeningTime{ContextType: entry.ContextType, ContextURI: entry.ContextURI}
+			byContext[key] = res
+		}
+		res.Listens++
+		res.ListeningTime += time.Duration(entry.DurationMs) * time.Millisecond
+	})
+
+	results := []ContextListeningTime{}
+	for _, res := range byContext {
+		results = append(results, *res)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].ListeningTime != results[j].ListeningTime {
+			return results[i].ListeningTime > results[j].ListeningTime
+		}
+		return results[i].ContextURI < results[j].ContextURI
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (rp *MemoryRepository) GetLeaderboard(
+	_ context.Context,
+	discordIDs []string,
+	since time.Time,
+	limit int,
+	offset int,
+) ([]LeaderboardEntry, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	results := []LeaderboardEntry{}
+	for _, discordID := range discordIDs {
+		res := LeaderboardEntry{DiscordID: discordID}
+		rp.userListens(discordID, func(entry BatchWriteListenEntry) {
+			if entry.PlayedAt.Before(since) {
+				return
+			}
+			res.Listens++
+			res.ListeningTime += time.Duration(entry.DurationMs) * time.Millisecond
+		})
+		if res.Listens > 0 {
+			results = append(results, res)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Listens != results[j].Listens {
+			return results[i].Listens > results[j].Listens
+		}
+		return results[i].DiscordID < results[j].DiscordID
+	})
+
+	if offset > len(results) {
+		offset = len(results)
+	}
+	results = results[offset:]
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// BatchWriteListens skips listens the user already has, like the ON CONFLICT
+// DO NOTHING in PostgresRepository.
+func (rp *MemoryRepository) BatchWriteListens(
+	_ context.Context,
+	discordID string,
+	entries []BatchWriteListenEntry,
+) (int64, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	return rp.writeListens(discordID, entries), nil
+}
+
+// writeListens adds the entries the user doesn't already have. rp.mu must be
+// held.
+func (rp *MemoryRepository) writeListens(discordID string, entries []BatchWriteListenEntry) int64 {
+	inserted := int64(0)
+	for _, entry := range entries {
+		key := listenKey{discordID: discordID, songID: entry.TrackID, time: entry.PlayedAt.UnixMicro()}
+		if _, ok := rp.listens[key]; ok {
+			continue
+		}
+		entry.PlayedAt = time.UnixMicro(key.time)
+		rp.listens[key] = entry
+		inserted++
+	}
+	return inserted
+}
+
+// ImportListens also skips listens within a second of one the user already
+// has of the same track, like PostgresRepository.
+func (rp *MemoryRepository) ImportListens(
+	_ context.Context,
+	discordID string,
+	entries []BatchWriteListenEntry,
+) (int64, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	fresh := make([]BatchWriteListenEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !rp.listenedNear(discordID, entry.TrackID, entry.PlayedAt) {
+			fresh = append(fresh, entry)
+		}
+	}
+	return rp.writeListens(discordID, fresh), nil
+}
+
+// listenedNear reports whether the user has a listen of the track less than a
+// second either side of at. rp.mu must be held.
+func (rp *MemoryRepository) listenedNear(discordID string, songID string, at time.Time) bool {
+	for key, entry := range rp.listens {
+		if key.discordID != discordID || key.songID != songID {
+			continue
+		}
+		diff := entry.PlayedAt.Sub(at)
+		if diff > -time.Second && diff < time.Second {
+			return true
+		}
+	}
+	return false
+}
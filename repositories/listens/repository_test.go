@@ -0,0 +1,221 @@
+package listens
+
+import (
+	"context"
+	"oscen/repositories/repotest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		return NewMemoryRepository()
+	})
+}
+
+func TestPostgresRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		return NewPostgresRepository(repotest.Postgres(t))
+	})
+}
+
+// testRepository checks a Repository behaves as the rest of Oscen expects.
+// newRepo must return an empty repository.
+func testRepository(t *testing.T, newRepo func(t *testing.T) Repository) {
+	ctx := context.Background()
+	base := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("empty", func(t *testing.T) {
+		rp := newRepo(t)
+
+		last, err := rp.GetUsersLastListenTime(ctx, "1")
+		require.NoError(t, err)
+		assert.Nil(t, last)
+
+		count, err := rp.GetUserListenCount(ctx, "1")
+		require.NoError(t, err)
+		assert.Zero(t, count)
+
+		listeningTime, err := rp.GetUserListeningTime(ctx, "1", base)
+		require.NoError(t, err)
+		assert.Zero(t, listeningTime)
+
+		contexts, err := rp.GetTopContexts(ctx, "1", base, 3)
+		require.NoError(t, err)
+		assert.Empty(t, contexts)
+
+		leaderboard, err := rp.GetLeaderboard(ctx, []string{"1"}, base, 10, 0)
+		require.NoError(t, err)
+		assert.Empty(t, leaderboard)
+
+		inserted, err := rp.BatchWriteListens(ctx, "1", nil)
+		require.NoError(t, err)
+		assert.Zero(t, inserted)
+	})
+
+	writers := map[string]func(rp Repository) func(ctx context.Context, discordID string, entries []BatchWriteListenEntry) (int64, error){
+		"batch write": func(rp Repository) func(context.Context, string, []BatchWriteListenEntry) (int64, error) {
+			return rp.BatchWriteListens
+		},
+		"import": func(rp Repository) func(context.Context, string, []BatchWriteListenEntry) (int64, error) {
+			return rp.ImportListens
+		},
+	}
+	for name, writer := range writers {
+		t.Run(name, func(t *testing.T) {
+			rp := newRepo(t)
+			write := writer(rp)
+
+			inserted, err := write(ctx, "1", []BatchWriteListenEntry{
+				{TrackID: "a", PlayedAt: base, DurationMs: 1000},
+				{TrackID: "b", PlayedAt: base.Add(time.Minute), DurationMs: 2000},
+				// Duplicates within a write are skipped too.
+				{TrackID: "b", PlayedAt: base.Add(time.Minute), DurationMs: 2000},
+			})
+			require.NoError(t, err)
+			assert.EqualValues(t, 2, inserted)
+
+			inserted, err = write(ctx, "1", []BatchWriteListenEntry{
+				{TrackID: "a", PlayedAt: base, DurationMs: 1000},
+				// The same track at a different time is a new listen.
+				{TrackID: "a", PlayedAt: base.Add(2 * time.Minute), DurationMs: 1000},
+				// Postgres only keeps microseconds.
+				{TrackID: "b", PlayedAt: base.Add(time.Minute + time.Nanosecond), DurationMs: 2000},
+			})
+			require.NoError(t, err)
+			assert.EqualValues(t, 1, inserted)
+
+			// Listens are per user.
+			inserted, err = write(ctx, "2", []BatchWriteListenEntry{
+				{TrackID: "a", PlayedAt: base, DurationMs: 1000},
+			})
+			require.NoError(t, err)
+			assert.EqualValues(t, 1, inserted)
+
+			count, err := rp.GetUserListenCount(ctx, "1")
+			require.NoError(t, err)
+			assert.Equal(t, 3, count)
+
+			count, err = rp.GetSongListenCount(ctx, "1", "a")
+			require.NoError(t, err)
+			assert.Equal(t, 2, count)
+
+			last, err := rp.GetUsersLastListenTime(ctx, "1")
+			require.NoError(t, err)
+			require.NotNil(t, last)
+			assert.True(t, base.Add(2*time.Minute).Equal(*last))
+		})
+	}
+
+	t.Run("import skips scraped listens", func(t *testing.T) {
+		rp := newRepo(t)
+
+		_, err := rp.BatchWriteListens(ctx, "1", []BatchWriteListenEntry{
+			{TrackID: "a", PlayedAt: base.Add(250 * time.Millisecond), DurationMs: 1000},
+		})
+		require.NoError(t, err)
+
+		// Exports only have seconds, so the same listen looks a little off.
+		inserted, err := rp.ImportListens(ctx, "1", []BatchWriteListenEntry{
+			{TrackID: "a", PlayedAt: base, DurationMs: 1000},
+			{TrackID: "b", PlayedAt: base, DurationMs: 1000},
+			{TrackID: "a", PlayedAt: base.Add(2 * time.Second), DurationMs: 1000},
+		})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, inserted)
+
+		count, err := rp.GetSongListenCount(ctx, "1", "a")
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("listening time", func(t *testing.T) {
+		rp := newRepo(t)
+
+		_, err := rp.BatchWriteListens(ctx, "1", []BatchWriteListenEntry{
+			{TrackID: "a", PlayedAt: base.Add(-time.Hour), DurationMs: 60000},
+			{TrackID: "b", PlayedAt: base, DurationMs: 1000},
+			{TrackID: "c", PlayedAt: base.Add(time.Hour), DurationMs: 2000},
+		})
+		require.NoError(t, err)
+
+		listeningTime, err := rp.GetUserListeningTime(ctx, "1", base)
+		require.NoError(t, err)
+		assert.Equal(t, 3*time.Second, listeningTime)
+	})
+
+	t.Run("top contexts", func(t *testing.T) {
+		rp := newRepo(t)
+
+		_, err := rp.BatchWriteListens(ctx, "1", []BatchWriteListenEntry{
+			{TrackID: "a", PlayedAt: base, ContextType: "album", ContextURI: "spotify:album:1", DurationMs: 1000},
+			{TrackID: "b", PlayedAt: base.Add(time.Minute), ContextType: "album", ContextURI: "spotify:album:1", DurationMs: 1000},
+			{TrackID: "c", PlayedAt: base.Add(2 * time.Minute), ContextType: "playlist", ContextURI: "spotify:playlist:2", DurationMs: 5000},
+			{TrackID: "d", PlayedAt: base.Add(3 * time.Minute), ContextType: "artist", ContextURI: "spotify:artist:3", DurationMs: 500},
+			// Listens without a context are left out.
+			{TrackID: "e", PlayedAt: base.Add(4 * time.Minute), DurationMs: 10000},
+			// As are those before since.
+			{TrackID: "f", PlayedAt: base.Add(-time.Minute), ContextType: "artist", ContextURI: "spotify:artist:3", DurationMs: 10000},
+		})
+		require.NoError(t, err)
+
+		contexts, err := rp.GetTopContexts(ctx, "1", base, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []ContextListeningTime{
+			{ContextType: "playlist", ContextURI: "spotify:playlist:2", Listens: 1, ListeningTime: 5 * time.Second},
+			{ContextType: "album", ContextURI: "spotify:album:1", Listens: 2, ListeningTime: 2 * time.Second},
+		}, contexts)
+	})
+
+	t.Run("leaderboard", func(t *testing.T) {
+		rp := newRepo(t)
+
+		for discordID, entries := range map[string][]BatchWriteListenEntry{
+			"1": {
+				{TrackID: "a", PlayedAt: base, DurationMs: 1000},
+				{TrackID: "b", PlayedAt: base.Add(time.Minute), DurationMs: 2000},
+			},
+			"2": {
+				{TrackID: "a", PlayedAt: base, DurationMs: 1000},
+				{TrackID: "b", PlayedAt: base.Add(time.Minute), DurationMs: 1000},
+				{TrackID: "c", PlayedAt: base.Add(2 * time.Minute), DurationMs: 1000},
+				// Listens before since don't count.
+				{TrackID: "d", PlayedAt: base.Add(-time.Minute), DurationMs: 1000},
+			},
+			"3": {
+				{TrackID: "a", PlayedAt: base, DurationMs: 5000},
+				{TrackID: "b", PlayedAt: base.Add(time.Minute), DurationMs: 5000},
+			},
+			// Not one of the users asked about.
+			"4": {
+				{TrackID: "a", PlayedAt: base, DurationMs: 1000},
+			},
+		} {
+			_, err := rp.BatchWriteListens(ctx, discordID, entries)
+			require.NoError(t, err)
+		}
+		discordIDs := []string{"1", "2", "3", "5"}
+
+		leaderboard, err := rp.GetLeaderboard(ctx, discordIDs, base, 10, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []LeaderboardEntry{
+			{DiscordID: "2", Listens: 3, ListeningTime: 3 * time.Second},
+			// Ties are broken by Discord ID.
+			{DiscordID: "1", Listens: 2, ListeningTime: 3 * time.Second},
+			{DiscordID: "3", Listens: 2, ListeningTime: 10 * time.Second},
+		}, leaderboard)
+
+		leaderboard, err = rp.GetLeaderboard(ctx, discordIDs, base, 1, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []LeaderboardEntry{
+			{DiscordID: "1", Listens: 2, ListeningTime: 3 * time.Second},
+		}, leaderboard)
+
+		leaderboard, err = rp.GetLeaderboard(ctx, discordIDs, base, 10, 3)
+		require.NoError(t, err)
+		assert.Empty(t, leaderboard)
+	})
+}
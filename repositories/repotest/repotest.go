@@ -0,0 +1,86 @@
+// Package repotest sets up Postgres for repository tests.
+package repotest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+// Postgres connects to the database in TEST_POSTGRESQL_URL, skipping the
+// test if it isn't set. Each call gets its own schema with the migrations
+// applied, which is dropped once the test is done.
+func Postgres(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	url := os.Getenv("TEST_POSTGRESQL_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRESQL_URL not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	schema := fmt.Sprintf("oscen_test_%d", rand.Int63())
+
+	admin, err := pgxpool.Connect(ctx, url)
+	require.NoError(t, err)
+	defer admin.Close()
+	_, err = admin.Exec(ctx, fmt.Sprintf("CREATE SCHEMA %s;", schema))
+	require.NoError(t, err)
+
+	cfg, err := pgxpool.ParseConfig(url)
+	require.NoError(t, err)
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	db, err := pgxpool.ConnectConfig(ctx, cfg)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		db.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		admin, err := pgxpool.Connect(ctx, url)
+		if err != nil {
+			t.Errorf("failed to drop test schema: %s", err)
+			return
+		}
+		defer admin.Close()
+		if _, err := admin.Exec(ctx, fmt.Sprintf("DROP SCHEMA %s CASCADE;", schema)); err != nil {
+			t.Errorf("failed to drop test schema: %s", err)
+		}
+	})
+
+	for _, path := range migrations(t) {
+		sql, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		_, err = db.Exec(ctx, string(sql))
+		require.NoError(t, err, "applying %s", filepath.Base(path))
+	}
+
+	return db
+}
+
+// migrations returns the up migrations in the order they are applied.
+func migrations(t *testing.T) []string {
+	_, file, _, ok := runtime.Caller(0)
+	require.True(t, ok)
+	dir := filepath.Join(filepath.Dir(file), "..", "..", "migrations")
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.up.sql"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths, "no migrations found in %s", dir)
+
+	sort.Strings(paths)
+	return paths
+}
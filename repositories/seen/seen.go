@@ -0,0 +1,97 @@
+// Package seen remembers which Discord interactions have been handled, so a
+// replayed or duplicated delivery isn't handled twice.
+package seen
+
+import (
+	"context"
+	"oscen/tracer"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type PostgresRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+// MarkSeen records the interaction as seen until the given time. It returns
+// false if it had already been seen and that hasn't expired.
+func (rp *PostgresRepository) MarkSeen(
+	ctx context.Context,
+	interactionID string,
+	until time.Time,
+) (bool, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.seen.mark_seen")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		INSERT INTO seen_interactions(id, expires_at)
+		VALUES($1, $2)
+		ON CONFLICT (id) DO UPDATE SET expires_at = EXCLUDED.expires_at
+		WHERE seen_interactions.expires_at < NOW();
+		`
+	tag, err := rp.db.Exec(ctx, sql, interactionID, until)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// DeleteExpired removes interactions that no longer need remembering.
+func (rp *PostgresRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.seen.delete_expired")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := "DELETE FROM seen_interactions WHERE expires_at < NOW();"
+	tag, err := rp.db.Exec(ctx, sql)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// MemoryRepository is MarkSeen for a single replica.
+type MemoryRepository struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	now     func() time.Time
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		expires: map[string]time.Time{},
+		now:     time.Now,
+	}
+}
+
+func (rp *MemoryRepository) MarkSeen(
+	_ context.Context,
+	interactionID string,
+	until time.Time,
+) (bool, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	now := rp.now()
+	// Clear out expired entries as we go, there's only a few minutes' worth.
+	for id, expires := range rp.expires {
+		if expires.Before(now) {
+			delete(rp.expires, id)
+		}
+	}
+
+	if _, ok := rp.expires[interactionID]; ok {
+		return false, nil
+	}
+	rp.expires[interactionID] = until
+	return true, nil
+}
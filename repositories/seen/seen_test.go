@@ -0,0 +1,36 @@
+package seen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	rp := NewMemoryRepository()
+	rp.now = func() time.Time { return now }
+
+	first, err := rp.MarkSeen(ctx, "1", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, first)
+
+	first, err = rp.MarkSeen(ctx, "1", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, first)
+
+	first, err = rp.MarkSeen(ctx, "2", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, first)
+
+	// Once expired it's forgotten.
+	now = now.Add(2 * time.Minute)
+	first, err = rp.MarkSeen(ctx, "1", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, first)
+	assert.Len(t, rp.expires, 1)
+}
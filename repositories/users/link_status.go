@@ -0,0 +1,70 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"oscen/tracer"
+
+	"golang.org/x/oauth2"
+)
+
+// LinkStatus describes the health of a user's Spotify link.
+type LinkStatus string
+
+const (
+	// LinkStatusActive is a link that is working as expected.
+	LinkStatusActive LinkStatus = "active"
+	// LinkStatusRevoked is a link that Spotify will no longer refresh, the
+	// user must /register again before we can use it.
+	LinkStatusRevoked LinkStatus = "revoked"
+	// LinkStatusErroring is a link that failed for some other reason. It
+	// will be retried.
+	LinkStatusErroring LinkStatus = "erroring"
+)
+
+var ErrLinkRevoked = fmt.Errorf("user's spotify link has been revoked")
+
+// IsRevokedGrant reports whether err was caused by Spotify refusing to
+// refresh the user's token, which happens when they revoke access to Oscen.
+func IsRevokedGrant(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return false
+	}
+
+	body := struct {
+		Error string `json:"error"`
+	}{}
+	if err := json.Unmarshal(retrieveErr.Body, &body); err != nil {
+		return false
+	}
+
+	return body.Error == "invalid_grant"
+}
+
+// SetLinkStatus updates the status of the user's link, reporting whether the
+// status actually changed.
+func (rp *PostgresRepository) SetLinkStatus(
+	ctx context.Context,
+	discordID string,
+	status LinkStatus,
+) (bool, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.users.set_link_status")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		UPDATE spotify_discord_links
+			SET status=$2, status_updated_at=NOW()
+		WHERE discord_id=$1 AND status <> $2;
+		`
+
+	tag, err := rp.db.Exec(ctx, sql, discordID, status)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
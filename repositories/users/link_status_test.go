@@ -0,0 +1,30 @@
+package users
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func TestIsRevokedGrant(t *testing.T) {
+	retrieveErr := func(body string) error {
+		return &url.Error{
+			Op:  "Get",
+			URL: "https://api.spotify.com/v1/me/player/recently-played",
+			Err: &oauth2.RetrieveError{
+				Response: &http.Response{Status: "400 Bad Request"},
+				Body:     []byte(body),
+			},
+		}
+	}
+
+	assert.True(t, IsRevokedGrant(retrieveErr(`{"error":"invalid_grant","error_description":"Refresh token revoked"}`)))
+	assert.False(t, IsRevokedGrant(retrieveErr(`{"error":"invalid_client"}`)))
+	assert.False(t, IsRevokedGrant(retrieveErr(`not json`)))
+	assert.False(t, IsRevokedGrant(fmt.Errorf("boom")))
+	assert.False(t, IsRevokedGrant(nil))
+}
@@ -0,0 +1,181 @@
+package users
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Repository stores users and their Spotify links.
+type Repository interface {
+	TokenStore
+	GetUsers(ctx context.Context) ([]User, error)
+	GetUserByDiscordID(ctx context.Context, discordID string) (*User, error)
+	GetUsersByDiscordIDs(ctx context.Context, discordIDs []string) ([]User, error)
+	UpsertUser(ctx context.Context, usr UpsertUser) error
+	SetLinkStatus(ctx context.Context, discordID string, status LinkStatus) (bool, error)
+	RecordScrapeFailure(ctx context.Context, discordID string, nextAttempt time.Time, cause error) error
+	RecordScrapeSuccess(ctx context.Context, discordID string) error
+}
+
+var (
+	_ Repository = (*PostgresRepository)(nil)
+	_ Repository = (*MemoryRepository)(nil)
+)
+
+// MemoryRepository is a Repository that doesn't need Postgres, for tests.
+type MemoryRepository struct {
+	mu    sync.Mutex
+	users map[string]*User
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{users: map[string]*User{}}
+}
+
+// copyUser stops callers changing the stored user.
+func copyUser(usr *User) *User {
+	cp := *usr
+	token := *usr.SpotifyToken
+	cp.SpotifyToken = &token
+	if usr.NextScrapeAt != nil {
+		next := *usr.NextScrapeAt
+		cp.NextScrapeAt = &next
+	}
+	return &cp
+}
+
+// storedToken is the token as Postgres would return it, which only stores
+// times to the microsecond.
+func storedToken(token *oauth2.Token) *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    "Bearer",
+		Expiry:       token.Expiry.Truncate(time.Microsecond),
+	}
+}
+
+func (rp *MemoryRepository) GetUsers(context.Context) ([]User, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	usrs := []User{}
+	for _, usr := range rp.users {
+		usrs = append(usrs, *copyUser(usr))
+	}
+	sort.Slice(usrs, func(i, j int) bool {
+		return usrs[i].DiscordID < usrs[j].DiscordID
+	})
+	return usrs, nil
+}
+
+func (rp *MemoryRepository) GetUserByDiscordID(_ context.Context, discordID string) (*User, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	usr, ok := rp.users[discordID]
+	if !ok {
+		return nil, ErrUserNotRegistered
+	}
+	return copyUser(usr), nil
+}
+
+func (rp *MemoryRepository) GetUsersByDiscordIDs(_ context.Context, discordIDs []string) ([]User, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	usrs := []User{}
+	seen := map[string]bool{}
+	for _, id := range discordIDs {
+		usr, ok := rp.users[id]
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		usrs = append(usrs, *copyUser(usr))
+	}
+	sort.Slice(usrs, func(i, j int) bool {
+		return usrs[i].DiscordID < usrs[j].DiscordID
+	})
+	return usrs, nil
+}
+
+func (rp *MemoryRepository) UpsertUser(_ context.Context, usr UpsertUser) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	existing, ok := rp.users[usr.DiscordID]
+	if !ok {
+		existing = &User{DiscordID: usr.DiscordID}
+		rp.users[usr.DiscordID] = existing
+	}
+	existing.SpotifyToken = storedToken(usr.SpotifyToken)
+	existing.Status = LinkStatusActive
+	// A fresh link deserves a fresh start from the scraper.
+	existing.ScrapeFailures = 0
+	existing.NextScrapeAt = nil
+	return nil
+}
+
+func (rp *MemoryRepository) UpdateToken(_ context.Context, discordID string, token *oauth2.Token) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	usr, ok := rp.users[discordID]
+	if !ok {
+		return nil
+	}
+	token = storedToken(token)
+	if usr.SpotifyToken.Expiry.Before(token.Expiry) {
+		usr.SpotifyToken = token
+	}
+	return nil
+}
+
+func (rp *MemoryRepository) SetLinkStatus(_ context.Context, discordID string, status LinkStatus) (bool, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	usr, ok := rp.users[discordID]
+	if !ok || usr.Status == status {
+		return false, nil
+	}
+	usr.Status = status
+	return true, nil
+}
+
+func (rp *MemoryRepository) RecordScrapeFailure(
+	_ context.Context,
+	discordID string,
+	nextAttempt time.Time,
+	_ error,
+) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	usr, ok := rp.users[discordID]
+	if !ok {
+		return nil
+	}
+	usr.ScrapeFailures++
+	next := nextAttempt.Truncate(time.Microsecond)
+	usr.NextScrapeAt = &next
+	return nil
+}
+
+func (rp *MemoryRepository) RecordScrapeSuccess(_ context.Context, discordID string) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	usr, ok := rp.users[discordID]
+	if !ok {
+		return nil
+	}
+	usr.ScrapeFailures = 0
+	usr.NextScrapeAt = nil
+	return nil
+}
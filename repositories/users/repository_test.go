@@ -0,0 +1,192 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"oscen/repositories/repotest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		return NewMemoryRepository()
+	})
+}
+
+func TestPostgresRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		return NewPostgresRepository(repotest.Postgres(t), nil)
+	})
+}
+
+// testRepository checks a Repository behaves as the rest of Oscen expects.
+// newRepo must return an empty repository.
+func testRepository(t *testing.T, newRepo func(t *testing.T) Repository) {
+	ctx := context.Background()
+	expiry := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	token := func(access string, expiry time.Time) *oauth2.Token {
+		return &oauth2.Token{AccessToken: access, RefreshToken: "refresh-" + access, Expiry: expiry}
+	}
+
+	t.Run("not registered", func(t *testing.T) {
+		rp := newRepo(t)
+
+		_, err := rp.GetUserByDiscordID(ctx, "1")
+		assert.Equal(t, ErrUserNotRegistered, err)
+
+		usrs, err := rp.GetUsers(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, usrs)
+
+		usrs, err = rp.GetUsersByDiscordIDs(ctx, []string{"1"})
+		require.NoError(t, err)
+		assert.Empty(t, usrs)
+
+		changed, err := rp.SetLinkStatus(ctx, "1", LinkStatusRevoked)
+		require.NoError(t, err)
+		assert.False(t, changed)
+
+		// Updates to unknown users are ignored.
+		require.NoError(t, rp.UpdateToken(ctx, "1", token("new", expiry)))
+		require.NoError(t, rp.RecordScrapeFailure(ctx, "1", expiry, errors.New("boom")))
+		require.NoError(t, rp.RecordScrapeSuccess(ctx, "1"))
+		_, err = rp.GetUserByDiscordID(ctx, "1")
+		assert.Equal(t, ErrUserNotRegistered, err)
+	})
+
+	t.Run("upsert", func(t *testing.T) {
+		rp := newRepo(t)
+
+		require.NoError(t, rp.UpsertUser(ctx, UpsertUser{DiscordID: "1", SpotifyToken: token("first", expiry)}))
+		require.NoError(t, rp.UpsertUser(ctx, UpsertUser{DiscordID: "2", SpotifyToken: token("other", expiry)}))
+
+		usr, err := rp.GetUserByDiscordID(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, "1", usr.DiscordID)
+		assert.Equal(t, "first", usr.SpotifyToken.AccessToken)
+		assert.Equal(t, "refresh-first", usr.SpotifyToken.RefreshToken)
+		assert.Equal(t, "Bearer", usr.SpotifyToken.TokenType)
+		assert.True(t, expiry.Equal(usr.SpotifyToken.Expiry))
+		assert.Equal(t, LinkStatusActive, usr.Status)
+		assert.Zero(t, usr.ScrapeFailures)
+		assert.Nil(t, usr.NextScrapeAt)
+
+		// Registering again relinks a revoked account, clearing any backoff.
+		_, err = rp.SetLinkStatus(ctx, "1", LinkStatusRevoked)
+		require.NoError(t, err)
+		require.NoError(t, rp.RecordScrapeFailure(ctx, "1", expiry, errors.New("boom")))
+		require.NoError(t, rp.UpsertUser(ctx, UpsertUser{DiscordID: "1", SpotifyToken: token("second", expiry)}))
+
+		usr, err = rp.GetUserByDiscordID(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, "second", usr.SpotifyToken.AccessToken)
+		assert.Equal(t, LinkStatusActive, usr.Status)
+		assert.Zero(t, usr.ScrapeFailures)
+		assert.Nil(t, usr.NextScrapeAt)
+
+		usrs, err := rp.GetUsers(ctx)
+		require.NoError(t, err)
+		ids := []string{}
+		for _, usr := range usrs {
+			ids = append(ids, usr.DiscordID)
+		}
+		assert.ElementsMatch(t, []string{"1", "2"}, ids)
+	})
+
+	t.Run("update token", func(t *testing.T) {
+		rp := newRepo(t)
+		require.NoError(t, rp.UpsertUser(ctx, UpsertUser{DiscordID: "1", SpotifyToken: token("first", expiry)}))
+
+		// An older token doesn't replace a newer one.
+		require.NoError(t, rp.UpdateToken(ctx, "1", token("older", expiry.Add(-time.Minute))))
+		usr, err := rp.GetUserByDiscordID(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, "first", usr.SpotifyToken.AccessToken)
+
+		require.NoError(t, rp.UpdateToken(ctx, "1", token("newer", expiry.Add(time.Hour))))
+		usr, err = rp.GetUserByDiscordID(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, "newer", usr.SpotifyToken.AccessToken)
+		assert.Equal(t, "refresh-newer", usr.SpotifyToken.RefreshToken)
+		assert.True(t, expiry.Add(time.Hour).Equal(usr.SpotifyToken.Expiry))
+	})
+
+	t.Run("link status", func(t *testing.T) {
+		rp := newRepo(t)
+		require.NoError(t, rp.UpsertUser(ctx, UpsertUser{DiscordID: "1", SpotifyToken: token("first", expiry)}))
+
+		changed, err := rp.SetLinkStatus(ctx, "1", LinkStatusActive)
+		require.NoError(t, err)
+		assert.False(t, changed)
+
+		changed, err = rp.SetLinkStatus(ctx, "1", LinkStatusRevoked)
+		require.NoError(t, err)
+		assert.True(t, changed)
+
+		usr, err := rp.GetUserByDiscordID(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, LinkStatusRevoked, usr.Status)
+	})
+
+	t.Run("scrape backoff", func(t *testing.T) {
+		rp := newRepo(t)
+		require.NoError(t, rp.UpsertUser(ctx, UpsertUser{DiscordID: "1", SpotifyToken: token("first", expiry)}))
+
+		next := expiry.Add(time.Minute + time.Nanosecond)
+		require.NoError(t, rp.RecordScrapeFailure(ctx, "1", next, errors.New("boom")))
+		require.NoError(t, rp.RecordScrapeFailure(ctx, "1", next, errors.New("boom")))
+
+		usr, err := rp.GetUserByDiscordID(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, 2, usr.ScrapeFailures)
+		require.NotNil(t, usr.NextScrapeAt)
+		// Postgres only keeps microseconds.
+		assert.True(t, expiry.Add(time.Minute).Equal(*usr.NextScrapeAt))
+
+		require.NoError(t, rp.RecordScrapeSuccess(ctx, "1"))
+		usr, err = rp.GetUserByDiscordID(ctx, "1")
+		require.NoError(t, err)
+		assert.Zero(t, usr.ScrapeFailures)
+		assert.Nil(t, usr.NextScrapeAt)
+	})
+
+	t.Run("get by discord ids", func(t *testing.T) {
+		rp := newRepo(t)
+		for _, id := range []string{"3", "1", "2"} {
+			require.NoError(t, rp.UpsertUser(ctx, UpsertUser{DiscordID: id, SpotifyToken: token(id, expiry)}))
+		}
+
+		usrs, err := rp.GetUsersByDiscordIDs(ctx, []string{"3", "4", "1", "3"})
+		require.NoError(t, err)
+		ids := []string{}
+		for _, usr := range usrs {
+			ids = append(ids, usr.DiscordID)
+		}
+		assert.Equal(t, []string{"1", "3"}, ids)
+		assert.Equal(t, "3", usrs[1].SpotifyToken.AccessToken)
+
+		usrs, err = rp.GetUsersByDiscordIDs(ctx, nil)
+		require.NoError(t, err)
+		assert.Empty(t, usrs)
+	})
+
+	t.Run("returned users are copies", func(t *testing.T) {
+		rp := newRepo(t)
+		require.NoError(t, rp.UpsertUser(ctx, UpsertUser{DiscordID: "1", SpotifyToken: token("first", expiry)}))
+
+		usr, err := rp.GetUserByDiscordID(ctx, "1")
+		require.NoError(t, err)
+		usr.SpotifyToken.AccessToken = "changed"
+		usr.Status = LinkStatusRevoked
+
+		usr, err = rp.GetUserByDiscordID(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, "first", usr.SpotifyToken.AccessToken)
+		assert.Equal(t, LinkStatusActive, usr.Status)
+	})
+}
@@ -0,0 +1,48 @@
+package users
+
+import (
+	"context"
+	"oscen/tracer"
+	"time"
+)
+
+// RecordScrapeFailure increments the user's consecutive scrape failures and
+// holds off scraping them again until nextAttempt.
+func (rp *PostgresRepository) RecordScrapeFailure(
+	ctx context.Context,
+	discordID string,
+	nextAttempt time.Time,
+	cause error,
+) error {
+	ctx, childSpan := tracer.Start(ctx, "repositories.users.record_scrape_failure")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		UPDATE spotify_discord_links
+			SET scrape_failures=scrape_failures + 1, next_scrape_at=$2, last_scrape_error=$3
+		WHERE discord_id=$1;
+		`
+
+	_, err := rp.db.Exec(ctx, sql, discordID, nextAttempt, cause.Error())
+	return err
+}
+
+// RecordScrapeSuccess clears any backoff held against the user.
+func (rp *PostgresRepository) RecordScrapeSuccess(
+	ctx context.Context,
+	discordID string,
+) error {
+	ctx, childSpan := tracer.Start(ctx, "repositories.users.record_scrape_success")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		UPDATE spotify_discord_links
+			SET scrape_failures=0, next_scrape_at=NULL, last_scrape_error=NULL
+		WHERE discord_id=$1 AND scrape_failures > 0;
+		`
+
+	_, err := rp.db.Exec(ctx, sql, discordID)
+	return err
+}
@@ -0,0 +1,66 @@
+package users
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+)
+
+// TokenStore persists Spotify tokens once the oauth2 transport has refreshed
+// them, so the next client built for the user starts from the new token.
+type TokenStore interface {
+	UpdateToken(ctx context.Context, discordID string, token *oauth2.Token) error
+}
+
+// persistingTokenSource wraps the token source used by the oauth2 transport
+// and writes any token it hasn't seen before back to the TokenStore.
+type persistingTokenSource struct {
+	ctx       context.Context
+	discordID string
+	src       oauth2.TokenSource
+	store     TokenStore
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func newPersistingTokenSource(
+	ctx context.Context,
+	discordID string,
+	initial *oauth2.Token,
+	src oauth2.TokenSource,
+	store TokenStore,
+) *persistingTokenSource {
+	return &persistingTokenSource{
+		ctx:       ctx,
+		discordID: discordID,
+		src:       src,
+		store:     store,
+		last:      initial,
+	}
+}
+
+func (ts *persistingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := ts.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.last != nil && ts.last.AccessToken == tok.AccessToken {
+		return tok, nil
+	}
+	ts.last = tok
+
+	// A failure to persist shouldn't fail the request that triggered the
+	// refresh, the token we hold is still perfectly usable.
+	if err := ts.store.UpdateToken(ts.ctx, ts.discordID, tok); err != nil {
+		trace.SpanFromContext(ts.ctx).RecordError(err)
+	}
+
+	return tok, nil
+}
@@ -0,0 +1,45 @@
+package users
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+type fakeTokenStore struct {
+	updates []*oauth2.Token
+}
+
+func (s *fakeTokenStore) UpdateToken(_ context.Context, _ string, token *oauth2.Token) error {
+	s.updates = append(s.updates, token)
+	return nil
+}
+
+type sequenceTokenSource struct {
+	tokens []*oauth2.Token
+}
+
+func (s *sequenceTokenSource) Token() (*oauth2.Token, error) {
+	tok := s.tokens[0]
+	if len(s.tokens) > 1 {
+		s.tokens = s.tokens[1:]
+	}
+	return tok, nil
+}
+
+func TestPersistingTokenSource(t *testing.T) {
+	initial := &oauth2.Token{AccessToken: "initial"}
+	refreshed := &oauth2.Token{AccessToken: "refreshed"}
+	store := &fakeTokenStore{}
+	src := &sequenceTokenSource{tokens: []*oauth2.Token{initial, initial, refreshed, refreshed}}
+
+	ts := newPersistingTokenSource(context.Background(), "123", initial, src, store)
+	for i := 0; i < 4; i++ {
+		_, err := ts.Token()
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, []*oauth2.Token{refreshed}, store.updates)
+}
@@ -3,10 +3,12 @@ package users
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"oscen/ratelimit"
 	"oscen/tracer"
+	"time"
 
 	"github.com/zmb3/spotify/v2"
-	spotifyauth "github.com/zmb3/spotify/v2/auth"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 
 	"github.com/jackc/pgx/v4"
@@ -17,22 +19,57 @@ import (
 )
 
 type PostgresRepository struct {
-	db *pgxpool.Pool
+	db             *pgxpool.Pool
+	spotifyLimiter *ratelimit.Limiter
 }
 
-func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
-	return &PostgresRepository{db: db}
+// NewPostgresRepository creates a repository whose users build Spotify
+// clients limited by spotifyLimiter. It should be shared by everything
+// talking to Spotify, as Spotify rate limits per app rather than per user.
+// If nil, requests aren't limited.
+func NewPostgresRepository(db *pgxpool.Pool, spotifyLimiter *ratelimit.Limiter) *PostgresRepository {
+	return &PostgresRepository{db: db, spotifyLimiter: spotifyLimiter}
 }
 
 type User struct {
 	DiscordID    string
 	SpotifyToken *oauth2.Token
+	Status       LinkStatus
+
+	// ScrapeFailures is the number of consecutive failed history scrapes,
+	// and NextScrapeAt when the scraper may next try the user.
+	ScrapeFailures int
+	NextScrapeAt   *time.Time
+
+	// limiter is the Spotify rate limiter of the repository the user was
+	// loaded from.
+	limiter *ratelimit.Limiter
+}
+
+// SpotifyAuth builds HTTP clients using a user's Spotify token. It is
+// satisfied by *spotifyauth.Authenticator.
+type SpotifyAuth interface {
+	Client(ctx context.Context, token *oauth2.Token) *http.Client
 }
 
-func (u *User) SpotifyClient(ctx context.Context, auth *spotifyauth.Authenticator) *spotify.Client {
-	http := auth.Client(ctx, u.SpotifyToken)
-	http.Transport = otelhttp.NewTransport(http.Transport)
-	return spotify.New(http)
+// SpotifyClient builds a Spotify client for the user. Tokens refreshed by the
+// underlying oauth2 transport are written back through store, and requests
+// share the rate limiter of the repository the user was loaded from.
+func (u *User) SpotifyClient(
+	ctx context.Context,
+	auth SpotifyAuth,
+	store TokenStore,
+	opts ...spotify.ClientOption,
+) *spotify.Client {
+	client := auth.Client(ctx, u.SpotifyToken)
+	if t, ok := client.Transport.(*oauth2.Transport); ok {
+		t.Source = newPersistingTokenSource(ctx, u.DiscordID, u.SpotifyToken, t.Source, store)
+	}
+	if u.limiter != nil {
+		client.Transport = u.limiter.Transport(client.Transport)
+	}
+	client.Transport = otelhttp.NewTransport(client.Transport)
+	return spotify.New(client, opts...)
 }
 
 func (rp *PostgresRepository) GetUsers(
@@ -42,7 +79,7 @@ func (rp *PostgresRepository) GetUsers(
 	defer childSpan.End()
 
 	//language=SQL
-	sql := "SELECT discord_id, access_token, refresh_token, expiry FROM spotify_discord_links;"
+	sql := "SELECT discord_id, access_token, refresh_token, expiry, status, scrape_failures, next_scrape_at FROM spotify_discord_links ORDER BY discord_id;"
 	r, err := rp.db.Query(ctx, sql)
 	if err != nil {
 		return nil, err
@@ -55,12 +92,16 @@ func (rp *PostgresRepository) GetUsers(
 			SpotifyToken: &oauth2.Token{
 				TokenType: "Bearer",
 			},
+			limiter: rp.spotifyLimiter,
 		}
 		err = r.Scan(
 			&data.DiscordID,
 			&data.SpotifyToken.AccessToken,
 			&data.SpotifyToken.RefreshToken,
 			&data.SpotifyToken.Expiry,
+			&data.Status,
+			&data.ScrapeFailures,
+			&data.NextScrapeAt,
 		)
 		if err != nil {
 			return nil, err
@@ -68,7 +109,7 @@ func (rp *PostgresRepository) GetUsers(
 		usrs = append(usrs, data)
 	}
 
-	if r.Err() != nil {
+	if err := r.Err(); err != nil {
 		return nil, err
 	}
 
@@ -85,13 +126,14 @@ func (rp *PostgresRepository) GetUserByDiscordID(
 	defer childSpan.End()
 
 	//language=SQL
-	sql := "SELECT discord_id, access_token, refresh_token, expiry FROM spotify_discord_links WHERE discord_id=$1 LIMIT 1;"
+	sql := "SELECT discord_id, access_token, refresh_token, expiry, status, scrape_failures, next_scrape_at FROM spotify_discord_links WHERE discord_id=$1 LIMIT 1;"
 	row := rp.db.QueryRow(ctx, sql, discordID)
 
 	data := User{
 		SpotifyToken: &oauth2.Token{
 			TokenType: "Bearer",
 		},
+		limiter: rp.spotifyLimiter,
 	}
 
 	err := row.Scan(
@@ -99,6 +141,9 @@ func (rp *PostgresRepository) GetUserByDiscordID(
 		&data.SpotifyToken.AccessToken,
 		&data.SpotifyToken.RefreshToken,
 		&data.SpotifyToken.Expiry,
+		&data.Status,
+		&data.ScrapeFailures,
+		&data.NextScrapeAt,
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -110,6 +155,49 @@ func (rp *PostgresRepository) GetUserByDiscordID(
 	return &data, nil
 }
 
+// GetUsersByDiscordIDs returns the registered users out of discordIDs,
+// ordered by Discord ID. Anyone who isn't registered is left out.
+func (rp *PostgresRepository) GetUsersByDiscordIDs(
+	ctx context.Context,
+	discordIDs []string,
+) ([]User, error) {
+	ctx, childSpan := tracer.Start(ctx, "repositories.users.get_users_by_discord_ids")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := "SELECT discord_id, access_token, refresh_token, expiry, status, scrape_failures, next_scrape_at FROM spotify_discord_links WHERE discord_id = ANY($1) ORDER BY discord_id;"
+	r, err := rp.db.Query(ctx, sql, discordIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	usrs := []User{}
+	for r.Next() {
+		data := User{
+			SpotifyToken: &oauth2.Token{
+				TokenType: "Bearer",
+			},
+			limiter: rp.spotifyLimiter,
+		}
+		err = r.Scan(
+			&data.DiscordID,
+			&data.SpotifyToken.AccessToken,
+			&data.SpotifyToken.RefreshToken,
+			&data.SpotifyToken.Expiry,
+			&data.Status,
+			&data.ScrapeFailures,
+			&data.NextScrapeAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		usrs = append(usrs, data)
+	}
+
+	return usrs, r.Err()
+}
+
 type UpsertUser struct {
 	DiscordID    string
 	SpotifyToken *oauth2.Token
@@ -128,10 +216,14 @@ func (rp *PostgresRepository) UpsertUser(
 			discord_id,
 			access_token,
 			refresh_token,
-			expiry
-		) VALUES($1, $2, $3, $4)
+			expiry,
+			status,
+			status_updated_at
+		) VALUES($1, $2, $3, $4, $5, NOW())
 		ON CONFLICT(discord_id) DO UPDATE
-			SET access_token=$2, refresh_token=$3, expiry=$4;
+			SET access_token=$2, refresh_token=$3, expiry=$4,
+				status=$5, status_updated_at=NOW(),
+				scrape_failures=0, next_scrape_at=NULL, last_scrape_error=NULL;
 		`
 
 	_, err := rp.db.Exec(
@@ -141,6 +233,37 @@ func (rp *PostgresRepository) UpsertUser(
 		usr.SpotifyToken.AccessToken,
 		usr.SpotifyToken.RefreshToken,
 		usr.SpotifyToken.Expiry,
+		LinkStatusActive,
+	)
+
+	return err
+}
+
+// UpdateToken stores a refreshed token for the user. The write is skipped if
+// the stored token expires later than the given one, so a slow refresh can't
+// clobber a newer token persisted by another client.
+func (rp *PostgresRepository) UpdateToken(
+	ctx context.Context,
+	discordID string,
+	token *oauth2.Token,
+) error {
+	ctx, childSpan := tracer.Start(ctx, "repositories.users.update_token")
+	defer childSpan.End()
+
+	//language=SQL
+	sql := `
+		UPDATE spotify_discord_links
+			SET access_token=$2, refresh_token=$3, expiry=$4
+		WHERE discord_id=$1 AND expiry < $4;
+		`
+
+	_, err := rp.db.Exec(
+		ctx,
+		sql,
+		discordID,
+		token.AccessToken,
+		token.RefreshToken,
+		token.Expiry,
 	)
 
 	return err
@@ -0,0 +1,57 @@
+package users
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"oscen/ratelimit"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+)
+
+type plainAuth struct{}
+
+func (plainAuth) Client(context.Context, *oauth2.Token) *http.Client {
+	return &http.Client{}
+}
+
+func TestSpotifyClientLimiter(t *testing.T) {
+	requests := int64(0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		_, _ = w.Write([]byte(`{"id": "someone"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	get := func(usr *User) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		client := usr.SpotifyClient(ctx, plainAuth{}, &fakeTokenStore{}, spotify.WithBaseURL(srv.URL+"/"))
+		_, err := client.CurrentUser(ctx)
+		return err
+	}
+
+	// Users built by hand aren't limited.
+	unlimited := &User{DiscordID: "1", SpotifyToken: &oauth2.Token{}}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, get(unlimited))
+	}
+	assert.EqualValues(t, 3, atomic.LoadInt64(&requests))
+
+	// Users from a repository share its limiter, which only allows one
+	// request here.
+	limiter := ratelimit.NewLimiter(0.001, 1)
+	limited := []*User{
+		{DiscordID: "2", SpotifyToken: &oauth2.Token{}, limiter: limiter},
+		{DiscordID: "3", SpotifyToken: &oauth2.Token{}, limiter: limiter},
+	}
+	require.NoError(t, get(limited[0]))
+	assert.Error(t, get(limited[1]))
+	assert.EqualValues(t, 4, atomic.LoadInt64(&requests))
+}